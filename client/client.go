@@ -0,0 +1,94 @@
+// Package client talks to the admin API of a running stubserver, it's meant to be
+// used by tests to create endpoints and verify the requests received.
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/guilherme-santos/stubserver"
+)
+
+type Client struct {
+	baseURL    string
+	HTTPClient *http.Client
+}
+
+// New returns a client to the stubserver running at baseURL, e.g. http://localhost:8080.
+func New(baseURL string) *Client {
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+// Count returns how many requests received match the method, url and headers
+// of req. Empty method or url match any request.
+func (c *Client) Count(req stubserver.ConfigRequest) (int, error) {
+	var resp struct {
+		Count int `json:"count"`
+	}
+
+	err := c.do(http.MethodPost, "/__admin/requests/count", req, &resp)
+	return resp.Count, err
+}
+
+// Verify returns an error if requests matching req were not received the number
+// of times expected, e.g.:
+//
+//	err := c.Verify(stubserver.ConfigRequest{Method: "POST", URL: "/users"}, stubserver.Exactly(1))
+func (c *Client) Verify(req stubserver.ConfigRequest, times stubserver.Times) error {
+	body := struct {
+		Request stubserver.ConfigRequest `json:"request"`
+		stubserver.Times
+	}{
+		Request: req,
+		Times:   times,
+	}
+
+	return c.do(http.MethodPost, "/__admin/requests/verify", body, nil)
+}
+
+func (c *Client) do(method, path string, body, result interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var respErr struct {
+			Error   string `json:"error"`
+			Message string `json:"message"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&respErr); err != nil || respErr.Message == "" {
+			return fmt.Errorf("stubserver responded %s", resp.Status)
+		}
+		return errors.New(respErr.Message)
+	}
+
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
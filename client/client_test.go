@@ -0,0 +1,53 @@
+package client_test
+
+import (
+	gohttp "net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/guilherme-santos/stubserver"
+	"github.com/guilherme-santos/stubserver/client"
+	"github.com/guilherme-santos/stubserver/http"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_Verify(t *testing.T) {
+	cfg := stubserver.Config{
+		Endpoints: []stubserver.ConfigRequest{
+			{URL: "/users", Method: "POST", Response: stubserver.ConfigResponse{StatusCode: gohttp.StatusCreated}},
+		},
+	}
+
+	srv := httptest.NewServer(http.NewHandler(cfg))
+	defer srv.Close()
+
+	for i := 0; i < 2; i++ {
+		req, err := gohttp.NewRequest(gohttp.MethodPost, srv.URL+"/users", nil)
+		assert.NoError(t, err)
+		req.Header.Set("X-Version", "2")
+
+		resp, err := gohttp.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	c := client.New(srv.URL)
+
+	createUser := stubserver.ConfigRequest{
+		Method:  "POST",
+		URL:     "/users",
+		Headers: gohttp.Header{"X-Version": []string{"2"}},
+	}
+
+	count, err := c.Count(createUser)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	assert.NoError(t, c.Verify(createUser, stubserver.Exactly(2)))
+	assert.NoError(t, c.Verify(createUser, stubserver.AtLeast(1)))
+	assert.Error(t, c.Verify(createUser, stubserver.Never()))
+	assert.NoError(t, c.Verify(stubserver.ConfigRequest{Method: "DELETE", URL: "/users"}, stubserver.Never()))
+
+	err = c.Verify(stubserver.ConfigRequest{URL: "~^/users$", Headers: gohttp.Header{"X-Version": []string{"1"}}}, stubserver.AtLeast(1))
+	assert.EqualError(t, err, "expected any method ~^/users$ to be received at least 1 times but it was received 0 times")
+}
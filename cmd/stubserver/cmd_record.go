@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/foodora/go-ranger/fdhttp"
+	"github.com/guilherme-santos/stubserver"
+	"github.com/guilherme-santos/stubserver/http"
+	"github.com/spf13/cobra"
+)
+
+var (
+	recordUpstream   string
+	recordOut        string
+	recordQuery      []string
+	recordHeaders    []string
+	recordInlineSize int
+)
+
+var recordCmd = &cobra.Command{
+	Use:   "record",
+	Short: "Run http server sending requests to upstream and recording them as stubs",
+	Run: func(cmd *cobra.Command, args []string) {
+		upstream, err := stubserver.ParseUpstream(recordUpstream)
+		if err != nil {
+			cmd.Println(err)
+			os.Exit(1)
+		}
+
+		router := fdhttp.NewRouter()
+
+		logMiddleware := fdhttp.NewLogMiddleware()
+		router.Use(logMiddleware.Middleware())
+
+		recorder := http.NewRecorder(upstream, recordOut)
+		recorder.Query = recordQuery
+		recorder.Headers = recordHeaders
+		recorder.InlineSize = recordInlineSize
+		router.Register(recorder)
+
+		srv := fdhttp.NewServer(port)
+		var errChan chan error
+		go func() {
+			errChan <- srv.Start(router)
+		}()
+
+		stopSignal := make(chan os.Signal, 2)
+		signal.Notify(stopSignal, os.Interrupt, syscall.SIGTERM)
+
+		// block until receive a SIGTERM or server.Start return
+		select {
+		case <-stopSignal:
+			err := srv.Stop()
+			if err != nil {
+				log.Fatal("Cannot stop gracefully: ", err)
+			}
+		case err := <-errChan:
+			log.Fatal("Cannot run http server: ", err)
+		}
+
+		log.Printf("Stubserver stopped successfully, stubs recorded in %s", recordOut)
+	},
+}
+
+func init() {
+	recordCmd.Flags().StringVarP(&port, "port", "p", "80", "port to run the server")
+	recordCmd.Flags().StringVar(&recordUpstream, "upstream", "", "base url where requests are sent, e.g. http://localhost:8081")
+	recordCmd.Flags().StringVar(&recordOut, "out", "", "directory where the config file "+http.RecordConfigFile+" and the response files are written")
+	recordCmd.Flags().StringSliceVar(&recordQuery, "query", []string{"*"}, "query string params distinguishing requests, * for all")
+	recordCmd.Flags().StringSliceVar(&recordHeaders, "header", nil, "headers distinguishing requests")
+	recordCmd.Flags().IntVar(&recordInlineSize, "inline-size", http.DefaultRecordInlineSize, "size of the largest body kept in the config file, larger ones are written to response files")
+	recordCmd.MarkFlagRequired("upstream")
+	recordCmd.MarkFlagRequired("out")
+	rootCmd.AddCommand(recordCmd)
+}
@@ -5,17 +5,21 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/foodora/go-ranger/fdhttp"
 	"github.com/guilherme-santos/stubserver"
 	"github.com/guilherme-santos/stubserver/http"
 	"github.com/spf13/cobra"
-	yaml "gopkg.in/yaml.v2"
 )
 
 var (
-	cfgFile string
-	port    string
+	cfgFile       string
+	port          string
+	watchInterval time.Duration
+	journalSize   int
+	strict        bool
+	upstream      string
 )
 
 var serveCmd = &cobra.Command{
@@ -35,30 +39,36 @@ var serveCmd = &cobra.Command{
 		}
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		f, err := os.Open(cfgFile)
+		cfg, err := stubserver.LoadConfig(cfgFile)
 		if err != nil {
 			cmd.Println(err)
 			os.Exit(1)
 		}
 
-		defer f.Close()
-
-		var cfg stubserver.Config
-
-		err = yaml.NewDecoder(f).Decode(&cfg)
-		if err != nil {
-			cmd.Printf("Cannot read yaml file %s: %s\n", cfgFile, err)
-			os.Exit(1)
-		}
-
 		router := fdhttp.NewRouter()
 
 		logMiddleware := fdhttp.NewLogMiddleware()
 		router.Use(logMiddleware.Middleware())
 
-		handler := http.NewHandler(cfg)
+		handler := http.NewHandler(stubserver.Config{})
+		err = handler.SetConfig(cfg)
+		if err != nil {
+			cmd.Printf("Invalid config file %s: %s\n", cfgFile, err)
+			os.Exit(1)
+		}
+		handler.Journal = http.NewJournal(journalSize)
+		handler.Strict = strict
+		if upstream != "" {
+			handler.Upstream, err = stubserver.ParseUpstream(upstream)
+			if err != nil {
+				cmd.Println(err)
+				os.Exit(1)
+			}
+		}
 		router.Register(handler)
 
+		go watchConfig(cfgFile, handler, watchInterval)
+
 		srv := fdhttp.NewServer(port)
 		var errChan chan error
 		go func() {
@@ -86,6 +96,10 @@ var serveCmd = &cobra.Command{
 func init() {
 	serveCmd.Flags().StringVarP(&cfgFile, "config", "c", "", "config file with spec of your stubs")
 	serveCmd.Flags().StringVarP(&port, "port", "p", "80", "port to run the server or specify using STUBSERVER_PORT envvar")
+	serveCmd.Flags().DurationVar(&watchInterval, "watch-interval", time.Second, "how often the config file is checked for changes, 0 disables it (SIGHUP always reloads it)")
+	serveCmd.Flags().IntVar(&journalSize, "journal-size", http.DefaultJournalSize, "how many requests are kept to be queried by the admin API, 0 disables it")
+	serveCmd.Flags().BoolVar(&strict, "strict", false, "requests not matching any endpoint receive 404 instead of the first endpoint with the same method")
+	serveCmd.Flags().StringVar(&upstream, "upstream", "", "base url where requests not matching any endpoint are sent, e.g. http://localhost:8081")
 	serveCmd.MarkFlagRequired("config")
 	rootCmd.AddCommand(serveCmd)
 }
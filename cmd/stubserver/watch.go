@@ -0,0 +1,61 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/guilherme-santos/stubserver"
+	"github.com/guilherme-santos/stubserver/http"
+)
+
+// watchConfig reloads filename into handler every time the file changes on disk
+// or the process receives a SIGHUP. If the new file is not valid the handler keeps
+// the config it already has. The file is checked every interval, 0 disables it.
+func watchConfig(filename string, handler *http.Handler, interval time.Duration) {
+	reloadSignal := make(chan os.Signal, 1)
+	signal.Notify(reloadSignal, syscall.SIGHUP)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	lastStat, _ := os.Stat(filename)
+
+	for {
+		select {
+		case <-reloadSignal:
+			log.Printf("SIGHUP received, reloading config file %s", filename)
+		case <-tick:
+			stat, err := os.Stat(filename)
+			if err != nil || !fileChanged(lastStat, stat) {
+				continue
+			}
+			lastStat = stat
+			log.Printf("Config file %s changed, reloading it", filename)
+		}
+
+		cfg, err := stubserver.LoadConfig(filename)
+		if err == nil {
+			err = handler.SetConfig(cfg)
+		}
+		if err != nil {
+			log.Printf("Cannot reload config, keeping the previous one: %s", err)
+			continue
+		}
+
+		log.Printf("Config file %s reloaded", filename)
+	}
+}
+
+func fileChanged(old, new os.FileInfo) bool {
+	if old == nil {
+		return true
+	}
+	return !old.ModTime().Equal(new.ModTime()) || old.Size() != new.Size()
+}
@@ -0,0 +1,547 @@
+package http
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/foodora/go-ranger/fdhttp"
+	"github.com/guilherme-santos/stubserver"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// AdminPath is the prefix of all admin API endpoints.
+const AdminPath = "/__admin"
+
+// Admin serves the admin API, it allows to change the endpoints while the server
+// is running:
+//
+//	GET    /__admin/endpoints       list all endpoints
+//	POST   /__admin/endpoints       create one endpoint (or a list of them)
+//	GET    /__admin/endpoints/{id}  show one endpoint
+//	PUT    /__admin/endpoints/{id}  replace one endpoint
+//	DELETE /__admin/endpoints/{id}  delete one endpoint
+//	POST   /__admin/reset           go back to the endpoints of the config file
+//	GET    /__admin/requests        list requests received, see adminListRequests
+//	DELETE /__admin/requests        remove all requests from the journal
+//	POST   /__admin/requests/count  count requests matching an endpoint criteria
+//	POST   /__admin/requests/verify check how many times a request was received
+//	POST   /__admin/explain         explain which endpoint matches a request and why
+//	GET    /__admin/counters        how many times the endpoints with responses were called
+//	DELETE /__admin/counters        start again the responses of all endpoints
+//	DELETE /__admin/counters/{id}   start again the responses of one endpoint
+//
+// Endpoints are sent using the same format of the config file, as JSON or YAML.
+func (h *Handler) Admin(w http.ResponseWriter, req *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(req.URL.Path, AdminPath), "/")
+	parts := strings.Split(path, "/")
+
+	switch {
+	case path == "endpoints":
+		switch req.Method {
+		case http.MethodGet:
+			h.adminListEndpoints(w, req)
+			return
+		case http.MethodPost:
+			h.adminCreateEndpoints(w, req)
+			return
+		}
+	case len(parts) == 2 && parts[0] == "endpoints":
+		switch req.Method {
+		case http.MethodGet:
+			h.adminGetEndpoint(w, req, parts[1])
+			return
+		case http.MethodPut:
+			h.adminReplaceEndpoint(w, req, parts[1])
+			return
+		case http.MethodDelete:
+			h.adminDeleteEndpoint(w, req, parts[1])
+			return
+		}
+	case path == "reset":
+		if req.Method == http.MethodPost {
+			h.adminReset(w, req)
+			return
+		}
+	case path == "requests":
+		switch req.Method {
+		case http.MethodGet:
+			h.adminListRequests(w, req)
+			return
+		case http.MethodDelete:
+			h.Journal.Reset()
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	case path == "explain":
+		if req.Method == http.MethodPost {
+			h.adminExplain(w, req)
+			return
+		}
+	case path == "requests/count":
+		if req.Method == http.MethodPost {
+			h.adminCountRequests(w, req)
+			return
+		}
+	case path == "requests/verify":
+		if req.Method == http.MethodPost {
+			h.adminVerifyRequests(w, req)
+			return
+		}
+	case path == "counters":
+		switch req.Method {
+		case http.MethodGet:
+			fdhttp.ResponseJSON(w, http.StatusOK, map[string]interface{}{
+				"counters": h.counters.all(),
+			})
+			return
+		case http.MethodDelete:
+			h.counters.reset("")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	case len(parts) == 2 && parts[0] == "counters":
+		if req.Method == http.MethodDelete {
+			h.adminResetCounter(w, req, parts[1])
+			return
+		}
+	default:
+		adminError(w, http.StatusNotFound, "not_found", fmt.Sprintf("admin endpoint %s doesn't exist", req.URL.Path))
+		return
+	}
+
+	adminError(w, http.StatusMethodNotAllowed, "method_not_allowed", fmt.Sprintf("method %s is not allowed in %s", req.Method, req.URL.Path))
+}
+
+func (h *Handler) adminListEndpoints(w http.ResponseWriter, req *http.Request) {
+	h.mu.RLock()
+	endpoints := make([]stubserver.ConfigRequest, 0, len(h.stubs)+len(h.cfg.Endpoints))
+	endpoints = append(endpoints, h.stubs...)
+	endpoints = append(endpoints, h.cfg.Endpoints...)
+	h.mu.RUnlock()
+
+	fdhttp.ResponseJSON(w, http.StatusOK, map[string]interface{}{
+		"endpoints": endpoints,
+	})
+}
+
+func (h *Handler) adminCreateEndpoints(w http.ResponseWriter, req *http.Request) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		adminError(w, http.StatusBadRequest, "invalid_body", err.Error())
+		return
+	}
+
+	// yaml is a superset of json so both formats are accepted here.
+	var (
+		endpoints []stubserver.ConfigRequest
+		single    bool
+	)
+	if err := yaml.Unmarshal(b, &endpoints); err != nil {
+		var endpoint stubserver.ConfigRequest
+		if err := yaml.Unmarshal(b, &endpoint); err != nil {
+			adminError(w, http.StatusBadRequest, "invalid_body", err.Error())
+			return
+		}
+		endpoints = []stubserver.ConfigRequest{endpoint}
+		single = true
+	}
+	endpoints = withIDs(endpoints)
+
+	h.mu.Lock()
+	// new endpoints take precedence over the ones already created
+	stubs := make([]stubserver.ConfigRequest, 0, len(endpoints)+len(h.stubs))
+	stubs = append(stubs, endpoints...)
+	stubs = append(stubs, h.stubs...)
+	err = h.apply(h.cfg, stubs)
+	h.mu.Unlock()
+
+	if err != nil {
+		adminError(w, http.StatusBadRequest, "invalid_endpoint", err.Error())
+		return
+	}
+
+	if single {
+		fdhttp.ResponseJSON(w, http.StatusCreated, endpoints[0])
+		return
+	}
+	fdhttp.ResponseJSON(w, http.StatusCreated, map[string]interface{}{
+		"endpoints": endpoints,
+	})
+}
+
+func (h *Handler) adminGetEndpoint(w http.ResponseWriter, req *http.Request, id string) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, endpoints := range [][]stubserver.ConfigRequest{h.stubs, h.cfg.Endpoints} {
+		if i := indexOfEndpoint(endpoints, id); i >= 0 {
+			fdhttp.ResponseJSON(w, http.StatusOK, endpoints[i])
+			return
+		}
+	}
+
+	adminEndpointNotFound(w, id)
+}
+
+func (h *Handler) adminReplaceEndpoint(w http.ResponseWriter, req *http.Request, id string) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		adminError(w, http.StatusBadRequest, "invalid_body", err.Error())
+		return
+	}
+
+	var endpoint stubserver.ConfigRequest
+	if err := yaml.Unmarshal(b, &endpoint); err != nil {
+		adminError(w, http.StatusBadRequest, "invalid_body", err.Error())
+		return
+	}
+	endpoint.ID = id
+
+	h.mu.Lock()
+	found := false
+	cfg, stubs := h.cfg, h.stubs
+	if i := indexOfEndpoint(stubs, id); i >= 0 {
+		stubs = replaceEndpoint(stubs, i, &endpoint)
+		found = true
+	} else if i := indexOfEndpoint(cfg.Endpoints, id); i >= 0 {
+		cfg.Endpoints = replaceEndpoint(cfg.Endpoints, i, &endpoint)
+		found = true
+	}
+	if found {
+		err = h.apply(cfg, stubs)
+	}
+	h.mu.Unlock()
+
+	if !found {
+		adminEndpointNotFound(w, id)
+		return
+	}
+	if err != nil {
+		adminError(w, http.StatusBadRequest, "invalid_endpoint", err.Error())
+		return
+	}
+	h.counters.reset(id)
+
+	fdhttp.ResponseJSON(w, http.StatusOK, endpoint)
+}
+
+func (h *Handler) adminDeleteEndpoint(w http.ResponseWriter, req *http.Request, id string) {
+	h.mu.Lock()
+	found := false
+	cfg, stubs := h.cfg, h.stubs
+	if i := indexOfEndpoint(stubs, id); i >= 0 {
+		stubs = replaceEndpoint(stubs, i, nil)
+		found = true
+	} else if i := indexOfEndpoint(cfg.Endpoints, id); i >= 0 {
+		cfg.Endpoints = replaceEndpoint(cfg.Endpoints, i, nil)
+		found = true
+	}
+	var err error
+	if found {
+		err = h.apply(cfg, stubs)
+	}
+	h.mu.Unlock()
+
+	if !found {
+		adminEndpointNotFound(w, id)
+		return
+	}
+	if err != nil {
+		adminError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	h.counters.reset(id)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) adminReset(w http.ResponseWriter, req *http.Request) {
+	h.mu.Lock()
+	err := h.apply(h.fileCfg, nil)
+	h.mu.Unlock()
+
+	if err != nil {
+		adminError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	h.counters.reset("")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) adminResetCounter(w http.ResponseWriter, req *http.Request, id string) {
+	h.mu.RLock()
+	found := indexOfEndpoint(h.stubs, id) >= 0 || indexOfEndpoint(h.cfg.Endpoints, id) >= 0
+	h.mu.RUnlock()
+
+	if !found {
+		adminEndpointNotFound(w, id)
+		return
+	}
+	h.counters.reset(id)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// adminListRequests returns the requests in the journal, they can be filtered by
+// query string:
+//
+//	method=POST
+//	path=/users or path=~^/users/[0-9]+$
+//	header=X-Version:2 (or only the name to check if it's present), can be repeated
+//	since=2018-05-07T19:22:13Z and until=2018-05-07T19:30:00Z
+//	matched=true or matched=false
+func (h *Handler) adminListRequests(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+
+	filter := JournalFilter{
+		Method:  q.Get("method"),
+		Path:    q.Get("path"),
+		Headers: http.Header{},
+	}
+
+	for _, header := range q["header"] {
+		parts := strings.SplitN(header, ":", 2)
+		value := ""
+		if len(parts) == 2 {
+			value = strings.TrimSpace(parts[1])
+		}
+		filter.Headers.Add(strings.TrimSpace(parts[0]), value)
+	}
+
+	var err error
+	if since := q.Get("since"); since != "" {
+		filter.Since, err = time.Parse(time.RFC3339, since)
+		if err != nil {
+			adminError(w, http.StatusBadRequest, "invalid_filter", fmt.Sprintf("since: %s", err))
+			return
+		}
+	}
+	if until := q.Get("until"); until != "" {
+		filter.Until, err = time.Parse(time.RFC3339, until)
+		if err != nil {
+			adminError(w, http.StatusBadRequest, "invalid_filter", fmt.Sprintf("until: %s", err))
+			return
+		}
+	}
+	if matched := q.Get("matched"); matched != "" {
+		b, err := strconv.ParseBool(matched)
+		if err != nil {
+			adminError(w, http.StatusBadRequest, "invalid_filter", fmt.Sprintf("matched: %s", err))
+			return
+		}
+		filter.Matched = &b
+	}
+
+	entries, err := h.Journal.Find(filter)
+	if err != nil {
+		adminError(w, http.StatusBadRequest, "invalid_filter", fmt.Sprintf("path: %s", err))
+		return
+	}
+
+	fdhttp.ResponseJSON(w, http.StatusOK, map[string]interface{}{
+		"requests": entries,
+	})
+}
+
+// adminCountRequests receives an endpoint, in the same format of the config file,
+// and returns how many requests in the journal match it. Method, url and headers
+// are compared, empty method or url match any request.
+func (h *Handler) adminCountRequests(w http.ResponseWriter, req *http.Request) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		adminError(w, http.StatusBadRequest, "invalid_body", err.Error())
+		return
+	}
+
+	var criteria stubserver.ConfigRequest
+	if err := yaml.Unmarshal(b, &criteria); err != nil {
+		adminError(w, http.StatusBadRequest, "invalid_body", err.Error())
+		return
+	}
+
+	count, err := h.countRequests(criteria)
+	if err != nil {
+		adminError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	fdhttp.ResponseJSON(w, http.StatusOK, map[string]interface{}{
+		"count": count,
+	})
+}
+
+// adminVerifyRequests receives the request to look for and how many times it's
+// expected, responds 417 if the journal doesn't satisfy it:
+//
+//	{"request": {"method": "POST", "url": "/users"}, "atleast": 1}
+func (h *Handler) adminVerifyRequests(w http.ResponseWriter, req *http.Request) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		adminError(w, http.StatusBadRequest, "invalid_body", err.Error())
+		return
+	}
+
+	var verification struct {
+		Request          stubserver.ConfigRequest
+		stubserver.Times `yaml:",inline"`
+	}
+	if err := yaml.Unmarshal(b, &verification); err != nil {
+		adminError(w, http.StatusBadRequest, "invalid_body", err.Error())
+		return
+	}
+
+	count, err := h.countRequests(verification.Request)
+	if err != nil {
+		adminError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	if !verification.Check(count) {
+		fdhttp.ResponseJSON(w, http.StatusExpectationFailed, map[string]interface{}{
+			"error":   "verification_failed",
+			"message": fmt.Sprintf("expected %s to be received %s but it was received %d times", describeRequest(verification.Request), verification.Times, count),
+			"count":   count,
+		})
+		return
+	}
+
+	fdhttp.ResponseJSON(w, http.StatusOK, map[string]interface{}{
+		"count": count,
+	})
+}
+
+// adminExplain receives a request, as {"method": "GET", "url": "/users?id=1",
+// "headers": {"Accept": ["application/json"]}, "body": "..."}, and returns the
+// endpoint chosen for it and the closest ones with the criteria that failed.
+func (h *Handler) adminExplain(w http.ResponseWriter, req *http.Request) {
+	var explainReq struct {
+		Method  string      `json:"method"`
+		URL     string      `json:"url"`
+		Headers http.Header `json:"headers"`
+		Body    string      `json:"body"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&explainReq); err != nil {
+		adminError(w, http.StatusBadRequest, "invalid_body", err.Error())
+		return
+	}
+
+	reqURL, err := url.ParseRequestURI(explainReq.URL)
+	if err != nil {
+		adminError(w, http.StatusBadRequest, "invalid_body", fmt.Sprintf("url: %s", err))
+		return
+	}
+
+	header := http.Header{}
+	for k, v := range explainReq.Headers {
+		header[http.CanonicalHeaderKey(k)] = v
+	}
+
+	fdhttp.ResponseJSON(w, http.StatusOK, h.explain(newRequest(explainReq.Method, reqURL, header, []byte(explainReq.Body))))
+}
+
+// countRequests returns how many requests in the journal match criteria.
+func (h *Handler) countRequests(criteria stubserver.ConfigRequest) (int, error) {
+	e, err := newEndpoint(criteria, h.matcher())
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, entry := range h.Journal.Entries() {
+		reqURL, err := url.Parse(entry.URL)
+		if err != nil {
+			continue
+		}
+		if e.match(newRequest(entry.Method, reqURL, entry.Headers, []byte(entry.Body))) {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func describeRequest(r stubserver.ConfigRequest) string {
+	method, url := strings.Join(endpointMethods(r), " or "), r.URL
+	if method == "" {
+		method = "any method"
+	}
+	if url == "" {
+		url = "any url"
+	}
+	return method + " " + url
+}
+
+func adminError(w http.ResponseWriter, statusCode int, code, message string) {
+	fdhttp.ResponseJSON(w, statusCode, map[string]interface{}{
+		"error":   code,
+		"message": message,
+	})
+}
+
+func adminEndpointNotFound(w http.ResponseWriter, id string) {
+	adminError(w, http.StatusNotFound, "not_found", fmt.Sprintf("endpoint '%s' doesn't exist", id))
+}
+
+func indexOfEndpoint(endpoints []stubserver.ConfigRequest, id string) int {
+	for i, endpoint := range endpoints {
+		if endpoint.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// replaceEndpoint returns a copy of endpoints with the endpoint at i replaced by
+// endpoint, or removed if endpoint is nil. The slice given is never modified
+// because it can be shared with the config loaded from the file.
+func replaceEndpoint(endpoints []stubserver.ConfigRequest, i int, endpoint *stubserver.ConfigRequest) []stubserver.ConfigRequest {
+	result := make([]stubserver.ConfigRequest, 0, len(endpoints))
+	result = append(result, endpoints[:i]...)
+	if endpoint != nil {
+		result = append(result, *endpoint)
+	}
+	return append(result, endpoints[i+1:]...)
+}
+
+// withIDs returns a copy of endpoints generating an ID for the ones without it.
+func withIDs(endpoints []stubserver.ConfigRequest) []stubserver.ConfigRequest {
+	result := make([]stubserver.ConfigRequest, len(endpoints))
+	for i, endpoint := range endpoints {
+		if endpoint.ID == "" {
+			endpoint.ID = newID()
+		}
+		result[i] = endpoint
+	}
+	return result
+}
+
+// withFileIDs is withIDs for the endpoints of the config, their IDs come from their
+// position, method and url to stay the same when the config is reloaded.
+func withFileIDs(endpoints []stubserver.ConfigRequest) []stubserver.ConfigRequest {
+	result := make([]stubserver.ConfigRequest, len(endpoints))
+	for i, endpoint := range endpoints {
+		if endpoint.ID == "" {
+			key := fmt.Sprintf("%d %s %s %s", i, endpoint.Method, strings.Join(endpoint.Methods, ","), endpoint.URL)
+			sum := sha1.Sum([]byte(key))
+			endpoint.ID = hex.EncodeToString(sum[:8])
+		}
+		result[i] = endpoint
+	}
+	return result
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,94 @@
+package http_test
+
+import (
+	"encoding/json"
+	gohttp "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/guilherme-santos/stubserver"
+	"github.com/guilherme-santos/stubserver/http"
+	"github.com/stretchr/testify/assert"
+)
+
+func adminRequest(h *http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	h.Admin(w, req)
+	return w
+}
+
+func stubRequest(h *http.Handler, method, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, nil)
+	h.Generic(w, req)
+	return w
+}
+
+func TestAdmin_Endpoints(t *testing.T) {
+	cfg := stubserver.Config{
+		Endpoints: []stubserver.ConfigRequest{
+			{ID: "file", URL: "/users", Method: "GET", Response: stubserver.ConfigResponse{Data: "from file", StatusCode: gohttp.StatusOK}},
+		},
+	}
+
+	h := http.NewHandler(cfg)
+
+	// create as json
+	w := adminRequest(h, gohttp.MethodPost, "/__admin/endpoints", `{"url": "/users", "method": "GET", "response": "from admin"}`)
+	assert.Equal(t, gohttp.StatusCreated, w.Code)
+
+	var created stubserver.ConfigRequest
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.NotEmpty(t, created.ID)
+	assert.Equal(t, "from admin", stubRequest(h, gohttp.MethodGet, "/users").Body.String())
+
+	// create as yaml
+	w = adminRequest(h, gohttp.MethodPost, "/__admin/endpoints", "url: /companies\nmethod: GET\nresponse: companies\n")
+	assert.Equal(t, gohttp.StatusCreated, w.Code)
+	assert.Equal(t, "companies", stubRequest(h, gohttp.MethodGet, "/companies").Body.String())
+
+	w = adminRequest(h, gohttp.MethodGet, "/__admin/endpoints", "")
+	assert.Equal(t, gohttp.StatusOK, w.Code)
+
+	var list struct {
+		Endpoints []stubserver.ConfigRequest
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Len(t, list.Endpoints, 3)
+	assert.Equal(t, "file", list.Endpoints[2].ID)
+
+	// replace
+	w = adminRequest(h, gohttp.MethodPut, "/__admin/endpoints/"+created.ID, `{"url": "/users", "method": "GET", "response": "replaced"}`)
+	assert.Equal(t, gohttp.StatusOK, w.Code)
+	assert.Equal(t, "replaced", stubRequest(h, gohttp.MethodGet, "/users").Body.String())
+
+	// delete
+	w = adminRequest(h, gohttp.MethodDelete, "/__admin/endpoints/"+created.ID, "")
+	assert.Equal(t, gohttp.StatusNoContent, w.Code)
+	assert.Equal(t, "from file", stubRequest(h, gohttp.MethodGet, "/users").Body.String())
+
+	w = adminRequest(h, gohttp.MethodDelete, "/__admin/endpoints/file", "")
+	assert.Equal(t, gohttp.StatusNoContent, w.Code)
+	assert.Equal(t, "companies", stubRequest(h, gohttp.MethodGet, "/users").Body.String())
+
+	w = adminRequest(h, gohttp.MethodDelete, "/__admin/endpoints/file", "")
+	assert.Equal(t, gohttp.StatusNotFound, w.Code)
+
+	// reset
+	w = adminRequest(h, gohttp.MethodPost, "/__admin/reset", "")
+	assert.Equal(t, gohttp.StatusNoContent, w.Code)
+	assert.Equal(t, "from file", stubRequest(h, gohttp.MethodGet, "/users").Body.String())
+	assert.Equal(t, "from file", stubRequest(h, gohttp.MethodGet, "/companies").Body.String())
+}
+
+func TestAdmin_InvalidEndpoint(t *testing.T) {
+	h := http.NewHandler(stubserver.Config{})
+
+	w := adminRequest(h, gohttp.MethodPost, "/__admin/endpoints", `{"url": "~/users/([0-9]", "method": "GET"}`)
+	assert.Equal(t, gohttp.StatusBadRequest, w.Code)
+
+	w = adminRequest(h, gohttp.MethodGet, "/__admin/endpoints", "")
+	assert.JSONEq(t, `{"endpoints": []}`, w.Body.String())
+}
@@ -0,0 +1,78 @@
+package http
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/guilherme-santos/stubserver"
+)
+
+// delayDuration returns how long to wait with d, the random distributions use rnd.
+func delayDuration(d *stubserver.ConfigDelay, rnd *rand.Rand) time.Duration {
+	switch {
+	case d == nil:
+		return 0
+	case d.Median > 0:
+		return time.Duration(float64(d.Median) * math.Exp(d.Sigma*rnd.NormFloat64()))
+	case d.Max > 0:
+		return d.Min + time.Duration(rnd.Int63n(int64(d.Max-d.Min)+1))
+	}
+	return d.Fixed
+}
+
+// sleep waits for duration, it returns false when ctx is done before, e.g. the
+// client closed the connection.
+func sleep(ctx context.Context, duration time.Duration) bool {
+	if duration <= 0 {
+		return ctx.Err() == nil
+	}
+
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// writeBody writes body to w, when d has Chunk the body is split in chunks sent
+// with the delay between them. It stops when ctx is done.
+func writeBody(ctx context.Context, w http.ResponseWriter, body []byte, d *stubserver.ConfigDelay, rnd *rand.Rand) {
+	if d == nil || d.Chunk == nil {
+		w.Write(body)
+		return
+	}
+
+	chunks := d.Chunks
+	if chunks == 0 {
+		chunks = stubserver.DefaultDelayChunks
+	}
+	size := (len(body) + chunks - 1) / chunks
+
+	flusher, _ := w.(http.Flusher)
+	for i := 0; len(body) > 0; i++ {
+		if i > 0 && !sleep(ctx, delayDuration(d.Chunk, rnd)) {
+			return
+		}
+
+		n := size
+		if n > len(body) {
+			n = len(body)
+		}
+		if _, err := w.Write(body[:n]); err != nil {
+			return
+		}
+		body = body[n:]
+
+		// each chunk is sent right away, not when the buffer is full
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package http_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	gohttp "net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/guilherme-santos/stubserver"
+	"github.com/guilherme-santos/stubserver/http"
+	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestGeneric_Delay(t *testing.T) {
+	var cfg stubserver.Config
+	err := yaml.Unmarshal([]byte(`
+delay: 30ms
+endpoints:
+  - url: /default
+    response: default
+  - url: /fixed
+    response:
+      data: fixed
+      delay:
+        fixed: 60ms
+  - url: /uniform
+    response:
+      data: uniform
+      delay:
+        min: 20ms
+        max: 40ms
+  - url: /lognormal
+    response:
+      data: lognormal
+      delay:
+        median: 20ms
+        sigma: 0.1
+`), &cfg)
+	assert.NoError(t, err)
+
+	h := http.NewHandler(cfg)
+
+	tests := []struct {
+		url string
+		min time.Duration
+	}{
+		{"/default", 30 * time.Millisecond},
+		{"/fixed", 60 * time.Millisecond},
+		{"/uniform", 20 * time.Millisecond},
+		{"/lognormal", time.Millisecond},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		start := time.Now()
+		h.Generic(w, httptest.NewRequest(gohttp.MethodGet, test.url, nil))
+
+		assert.True(t, time.Since(start) >= test.min, "%s took %s", test.url, time.Since(start))
+		assert.Equal(t, test.url[1:], w.Body.String())
+	}
+}
+
+func TestGeneric_DelayChunks(t *testing.T) {
+	h := http.NewHandler(stubserver.Config{
+		Endpoints: []stubserver.ConfigRequest{
+			{
+				Response: stubserver.ConfigResponse{
+					Data: "abcdef",
+					Delay: &stubserver.ConfigDelay{
+						Chunk:  &stubserver.ConfigDelay{Fixed: 30 * time.Millisecond},
+						Chunks: 3,
+					},
+				},
+			},
+		},
+	})
+
+	server := httptest.NewServer(gohttp.HandlerFunc(h.Generic))
+	defer server.Close()
+
+	start := time.Now()
+	resp, err := gohttp.Get(server.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	// headers and the first chunk don't wait
+	assert.True(t, time.Since(start) < 30*time.Millisecond, "headers took %s", time.Since(start))
+	assert.Equal(t, "6", resp.Header.Get("Content-Length"))
+
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "abcdef", string(body))
+	assert.True(t, time.Since(start) >= 60*time.Millisecond, "body took %s", time.Since(start))
+}
+
+func TestGeneric_DelayCanceled(t *testing.T) {
+	h := http.NewHandler(stubserver.Config{
+		Delay: &stubserver.ConfigDelay{Fixed: 5 * time.Second},
+		Endpoints: []stubserver.ConfigRequest{
+			{Response: stubserver.ConfigResponse{Data: "late"}},
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	w := httptest.NewRecorder()
+	start := time.Now()
+	h.Generic(w, httptest.NewRequest(gohttp.MethodGet, "/", nil).WithContext(ctx))
+
+	assert.True(t, time.Since(start) < time.Second, "request took %s", time.Since(start))
+	assert.False(t, w.Flushed)
+	assert.Empty(t, w.Body.String())
+}
+
+func TestConfigDelay_UnmarshalYAML(t *testing.T) {
+	tests := []struct {
+		yaml     string
+		expected stubserver.ConfigDelay
+		err      string
+	}{
+		{yaml: `200ms`, expected: stubserver.ConfigDelay{Fixed: 200 * time.Millisecond}},
+		{yaml: `{min: 1s, max: 1.5s}`, expected: stubserver.ConfigDelay{Min: time.Second, Max: 1500 * time.Millisecond}},
+		{yaml: `{median: 200ms, sigma: 0.5, chunk: 10ms, chunks: 5}`, expected: stubserver.ConfigDelay{
+			Median: 200 * time.Millisecond,
+			Sigma:  0.5,
+			Chunk:  &stubserver.ConfigDelay{Fixed: 10 * time.Millisecond},
+			Chunks: 5,
+		}},
+		{yaml: `200`, err: "UnmarshalYAML: delay: time: missing unit in duration"},
+		{yaml: `{fixed: 1s, max: 2s}`, err: "UnmarshalYAML: delay: only one of fixed, min and max, or median and sigma can be used"},
+		{yaml: `{min: 2s, max: 1s}`, err: "UnmarshalYAML: delay: max 1s is less than min 2s"},
+		{yaml: `{median: 1s, sigma: -1}`, err: "UnmarshalYAML: delay: sigma cannot be negative"},
+		{yaml: `{chunk: {min: 1x}}`, err: `UnmarshalYAML: delay: min: time: unknown unit`},
+	}
+
+	for _, test := range tests {
+		var delay stubserver.ConfigDelay
+		err := yaml.Unmarshal([]byte(test.yaml), &delay)
+		if test.err != "" {
+			if assert.Error(t, err, test.yaml) {
+				assert.Contains(t, err.Error(), test.err, test.yaml)
+			}
+			continue
+		}
+		assert.NoError(t, err, test.yaml)
+		assert.Equal(t, test.expected, delay, test.yaml)
+
+		// the admin API reads endpoints written as JSON
+		b, err := json.Marshal(delay)
+		assert.NoError(t, err)
+		var decoded stubserver.ConfigDelay
+		assert.NoError(t, yaml.Unmarshal(b, &decoded), string(b))
+		assert.Equal(t, delay, decoded, string(b))
+	}
+}
+
+func TestSetConfig_InvalidDelay(t *testing.T) {
+	invalid := &stubserver.ConfigDelay{Min: time.Second, Max: time.Millisecond}
+
+	tests := []struct {
+		cfg      stubserver.Config
+		expected string
+	}{
+		{
+			stubserver.Config{Delay: invalid},
+			"delay: max 1ms is less than min 1s",
+		},
+		{
+			stubserver.Config{Endpoints: []stubserver.ConfigRequest{
+				{Response: stubserver.ConfigResponse{Delay: invalid}},
+			}},
+			"endpoint #0: delay: max 1ms is less than min 1s",
+		},
+		{
+			stubserver.Config{Endpoints: []stubserver.ConfigRequest{
+				{Responses: []stubserver.ConfigResponse{{}, {Delay: &stubserver.ConfigDelay{Chunk: invalid}}}},
+			}},
+			"endpoint #0: responses #1: delay: chunk: max 1ms is less than min 1s",
+		},
+	}
+
+	for _, test := range tests {
+		h := http.NewHandler(stubserver.Config{})
+		err := h.SetConfig(test.cfg)
+		if assert.Error(t, err, test.expected) {
+			assert.Equal(t, test.expected, err.Error())
+		}
+	}
+}
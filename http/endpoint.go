@@ -0,0 +1,660 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/guilherme-santos/stubserver"
+)
+
+// endpoint is the compiled form of a stubserver.ConfigRequest. Everything that can
+// be prepared before serving requests is done once when the config is loaded, after
+// that it's read-only and shared between all requests.
+type endpoint struct {
+	stubserver.ConfigRequest
+
+	// methods are Method and Methods in upper case, nil when any method matches
+	methods []string
+	// urlRegex is set when URL is a regex (starts with ~)
+	urlRegex *regexp.Regexp
+	// url is set when URL is a path, query contains its query string
+	url   *url.URL
+	query url.Values
+	// pathRegex is also set when the path has named parameters, e.g. /users/{id}
+	pathRegex *regexp.Regexp
+	// jsonPaths are the compiled Body.JSONPath
+	jsonPaths []endpointJSONPath
+	// headerMatchers and queryMatchers are the compiled HeaderMatchers and Query
+	headerMatchers map[string]*valueMatcher
+	queryMatchers  map[string]*valueMatcher
+	// tmpl is the template of inline responses, file responses are parsed when
+	// they're read.
+	tmpl bodyTemplate
+	// headerTmpl and statusTmpl are the templates of Response.Headers and
+	// Response.StatusCodeTemplate
+	headerTmpl headerTemplate
+	statusTmpl bodyTemplate
+	// fileTmpl is the template of the file name when it has templates, fileNotFound
+	// is sent when the file doesn't exist.
+	fileTmpl     bodyTemplate
+	fileNotFound *endpoint
+	// funcs are used by the templates of inline and file responses, dir is where
+	// the files with templates are.
+	funcs map[string]interface{}
+	dir   string
+	files fileCache
+	// delay is Response.Delay or the delay of the config, its random durations use rnd
+	delay *stubserver.ConfigDelay
+	rnd   *rand.Rand
+	// fault is Response.Fault
+	fault *stubserver.ConfigFault
+	// responses are the compiled Responses, they have the same ID of the endpoint
+	responses []*endpoint
+}
+
+// endpointMethods returns the methods of cfg in upper case, nil when it matches
+// any method.
+func endpointMethods(cfg stubserver.ConfigRequest) []string {
+	var methods []string
+	for _, m := range append([]string{cfg.Method}, cfg.Methods...) {
+		m = strings.ToUpper(strings.TrimSpace(m))
+		if m == stubserver.MethodAny {
+			return nil
+		}
+		if m != "" {
+			methods = append(methods, m)
+		}
+	}
+	return methods
+}
+
+// matchMethod reports whether the endpoint accepts method.
+func (e *endpoint) matchMethod(method string) bool {
+	if e.methods == nil {
+		return true
+	}
+	for _, m := range e.methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+type endpointJSONPath struct {
+	stubserver.ConfigJSONPath
+
+	path  jsonPath
+	regex *regexp.Regexp
+}
+
+// matcher holds all endpoints of a config already compiled.
+type matcher struct {
+	endpoints []*endpoint
+	strict    bool
+	fallback  *endpoint
+	upstream  *url.URL
+	// funcs are the functions of the templates of all endpoints
+	funcs map[string]interface{}
+	// dir is where response files with templates are, see Config.Dir
+	dir string
+	// delay is the default delay of responses, rnd is used by random delays
+	delay *stubserver.ConfigDelay
+	rnd   *rand.Rand
+}
+
+func newMatcher(cfg stubserver.Config) (*matcher, error) {
+	m := &matcher{
+		endpoints: make([]*endpoint, 0, len(cfg.Endpoints)),
+		strict:    cfg.Strict,
+		dir:       cfg.Dir,
+		delay:     cfg.Delay,
+	}
+
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	m.funcs = newTemplateFuncs(newRand(seed))
+	// delays have their own rand to not change the values of the templates
+	m.rnd = newRand(seed)
+
+	if cfg.Upstream != "" {
+		var err error
+		m.upstream, err = stubserver.ParseUpstream(cfg.Upstream)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Fallback != nil {
+		var err error
+		m.fallback, err = newEndpoint(stubserver.ConfigRequest{
+			ID:       "fallback",
+			Response: *cfg.Fallback,
+		}, m)
+		if err != nil {
+			return nil, fmt.Errorf("fallback: %s", err)
+		}
+	}
+
+	for i, cfgEndpoint := range cfg.Endpoints {
+		e, err := newEndpoint(cfgEndpoint, m)
+		if err != nil {
+			return nil, fmt.Errorf("endpoint #%d %s %s: %s", i, cfgEndpoint.Method, cfgEndpoint.URL, err)
+		}
+		m.endpoints = append(m.endpoints, e)
+	}
+
+	return m, nil
+}
+
+// newEndpoint compiles cfg, its templates use the functions and the directory of m.
+func newEndpoint(cfg stubserver.ConfigRequest, m *matcher) (*endpoint, error) {
+	e := &endpoint{
+		ConfigRequest: cfg,
+		methods:       endpointMethods(cfg),
+		funcs:         m.funcs,
+		dir:           m.dir,
+		delay:         cfg.Response.Delay,
+		rnd:           m.rnd,
+		fault:         cfg.Response.Fault,
+	}
+	if e.delay == nil {
+		e.delay = m.delay
+	}
+	funcs := m.funcs
+
+	var err error
+
+	if strings.HasPrefix(cfg.URL, "~") {
+		e.urlRegex, err = regexp.Compile(strings.TrimSpace(cfg.URL[1:]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex: %s", err)
+		}
+	} else if strings.Contains(cfg.URL, "{") {
+		path, rawQuery := splitPathPattern(cfg.URL)
+		e.pathRegex, err = compilePathPattern(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path pattern: %s", err)
+		}
+		e.url = &url.URL{Path: path, RawQuery: rawQuery}
+		e.query, err = url.ParseQuery(rawQuery)
+		if err != nil {
+			return nil, fmt.Errorf("invalid query string: %s", err)
+		}
+	} else {
+		e.url, err = url.ParseRequestURI(cfg.URL)
+		if err != nil && cfg.URL != "" {
+			return nil, fmt.Errorf("invalid url: %s", err)
+		}
+		if e.url == nil {
+			e.url = &url.URL{}
+		}
+		e.query = e.url.Query()
+	}
+
+	e.headerMatchers = make(map[string]*valueMatcher, len(cfg.HeaderMatchers))
+	for k, m := range cfg.HeaderMatchers {
+		e.headerMatchers[http.CanonicalHeaderKey(k)], err = newValueMatcher(m)
+		if err != nil {
+			return nil, fmt.Errorf("header '%s': %s", k, err)
+		}
+	}
+
+	e.queryMatchers = make(map[string]*valueMatcher, len(cfg.Query))
+	for k, m := range cfg.Query {
+		e.queryMatchers[k], err = newValueMatcher(m)
+		if err != nil {
+			return nil, fmt.Errorf("query string '%s': %s", k, err)
+		}
+	}
+
+	if cfg.Body != nil {
+		for _, p := range cfg.Body.JSONPath {
+			compiled := endpointJSONPath{ConfigJSONPath: p}
+
+			compiled.path, err = compileJSONPath(p.Path)
+			if err != nil {
+				return nil, err
+			}
+			if p.Regex != "" {
+				compiled.regex, err = regexp.Compile(p.Regex)
+				if err != nil {
+					return nil, fmt.Errorf("jsonpath '%s': invalid regex: %s", p.Path, err)
+				}
+			}
+
+			e.jsonPaths = append(e.jsonPaths, compiled)
+		}
+	}
+
+	if cfg.Response.Data != "" && !strings.HasPrefix(cfg.Response.Data, "@") {
+		e.tmpl, err = parseTemplate("data", cfg.Response.Data, cfg.Response.Template, cfg.Response.Headers, funcs)
+		if err != nil {
+			return nil, err
+		}
+	}
+	e.headerTmpl, err = parseHeaderTemplate("response", cfg.Response.Headers, funcs)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Response.StatusCodeTemplate != "" {
+		e.statusTmpl, err = parseStatusCodeTemplate("response", cfg.Response.StatusCodeTemplate, funcs)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if strings.HasPrefix(cfg.Response.Data, "@") && strings.Contains(cfg.Response.Data, "{{") {
+		e.fileTmpl, err = parseFileNameTemplate(cfg.Response.Data[1:], funcs)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Response.FileNotFound != nil {
+			e.fileNotFound, err = newEndpoint(stubserver.ConfigRequest{
+				ID:       cfg.ID,
+				Response: *cfg.Response.FileNotFound,
+			}, m)
+			if err != nil {
+				return nil, fmt.Errorf("filenotfound: %s", err)
+			}
+		}
+	} else if strings.HasPrefix(cfg.Response.Data, "@") {
+		// it's read here to check it, requests read it again only if it changes
+		if _, err := e.responseFile(e.resolveFilename(cfg.Response.Data[1:])); err != nil {
+			return nil, err
+		}
+	}
+
+	switch cfg.ResponseMode {
+	case "", stubserver.ResponseSequence, stubserver.ResponseCycle, stubserver.ResponseRandom:
+	default:
+		return nil, fmt.Errorf("unknown response mode '%s'", cfg.ResponseMode)
+	}
+	for i, r := range cfg.Responses {
+		response, err := newEndpoint(stubserver.ConfigRequest{
+			ID:       cfg.ID,
+			Response: r,
+		}, m)
+		if err != nil {
+			return nil, fmt.Errorf("responses #%d: %s", i, err)
+		}
+		e.responses = append(e.responses, response)
+	}
+
+	return e, nil
+}
+
+func (p endpointJSONPath) match(values []interface{}) bool {
+	if p.Exists != nil {
+		return *p.Exists == (len(values) > 0)
+	}
+	if len(values) == 0 {
+		return false
+	}
+
+	for _, v := range values {
+		if p.Equals != nil && !reflect.DeepEqual(p.Equals, v) {
+			continue
+		}
+		if p.regex != nil {
+			str, ok := v.(string)
+			if !ok {
+				b, _ := json.Marshal(v)
+				str = string(b)
+			}
+			if !p.regex.MatchString(str) {
+				continue
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+// jsonPathValues returns the values selected by the jsonpath criteria, by name or
+// by path when name is empty. A path selecting only one value gives the value itself.
+func (e *endpoint) jsonPathValues(req *request) map[string]interface{} {
+	values := map[string]interface{}{}
+
+	doc, ok := req.json()
+	if !ok {
+		return values
+	}
+
+	for _, p := range e.jsonPaths {
+		key := p.Name
+		if key == "" {
+			key = p.Path
+		}
+
+		selected := p.path.eval(doc)
+		switch len(selected) {
+		case 0:
+		case 1:
+			values[key] = selected[0]
+		default:
+			values[key] = selected
+		}
+	}
+
+	return values
+}
+
+// containsJSON reports whether expected is part of actual: objects must have all
+// fields expected, arrays all items expected (in any order) and other values must
+// be equal.
+func containsJSON(actual, expected interface{}) bool {
+	switch expected := expected.(type) {
+	case map[string]interface{}:
+		actual, ok := actual.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for k, ev := range expected {
+			av, ok := actual[k]
+			if !ok || !containsJSON(av, ev) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		actual, ok := actual.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, ev := range expected {
+			found := false
+			for _, av := range actual {
+				if containsJSON(av, ev) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	}
+
+	return reflect.DeepEqual(actual, expected)
+}
+
+// request is what endpoints are matched against.
+type request struct {
+	method string
+	url    *url.URL
+	header http.Header
+	body   []byte
+
+	jsonDecoded bool
+	jsonDoc     interface{}
+	jsonErr     error
+}
+
+func newRequest(method string, reqURL *url.URL, header http.Header, body []byte) *request {
+	return &request{
+		method: method,
+		url:    reqURL,
+		header: header,
+		body:   body,
+	}
+}
+
+// json returns the body decoded, ok is false when body is not valid JSON.
+func (r *request) json() (doc interface{}, ok bool) {
+	if !r.jsonDecoded {
+		r.jsonDecoded = true
+		r.jsonErr = json.Unmarshal(r.body, &r.jsonDoc)
+	}
+	return r.jsonDoc, r.jsonErr == nil
+}
+
+// matchPath reports whether the path of reqURL matches the endpoint, it's not used
+// when URL is a regex.
+func (e *endpoint) matchPath(reqURL *url.URL) bool {
+	if e.pathRegex != nil {
+		return e.pathRegex.MatchString(reqURL.Path)
+	}
+	return strings.EqualFold(reqURL.EscapedPath(), e.url.EscapedPath())
+}
+
+// params returns the named parameters of the path, or the named groups when URL
+// is a regex.
+func (e *endpoint) params(reqURL *url.URL) map[string]string {
+	params := map[string]string{}
+
+	var (
+		regex  *regexp.Regexp
+		values []string
+	)
+	switch {
+	case e.urlRegex != nil:
+		regex = e.urlRegex
+		values = regex.FindStringSubmatch(reqURL.String())
+	case e.pathRegex != nil:
+		regex = e.pathRegex
+		values = regex.FindStringSubmatch(reqURL.Path)
+	default:
+		return params
+	}
+
+	for i, name := range regex.SubexpNames() {
+		if name != "" && i < len(values) {
+			params[name] = values[i]
+		}
+	}
+
+	return params
+}
+
+// matchValues reports whether actual has all keys in expected, an empty expected
+// value only requires the key to be present, otherwise one of the values must be
+// equal to it. It's used for headers and query string.
+func matchValues(expected, actual map[string][]string) bool {
+	for k, ev := range expected {
+		rv, ok := actual[k]
+		if !ok {
+			return false
+		}
+		if len(ev) > 0 && ev[0] != "" && !containsFold(rv, ev[0]) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// valueMatcher is the compiled form of stubserver.ValueMatcher.
+type valueMatcher struct {
+	stubserver.ValueMatcher
+
+	regex *regexp.Regexp
+	not   *valueMatcher
+}
+
+func newValueMatcher(cfg *stubserver.ValueMatcher) (*valueMatcher, error) {
+	m := &valueMatcher{}
+	if cfg == nil {
+		return m, nil
+	}
+	m.ValueMatcher = *cfg
+
+	var err error
+
+	if cfg.Regex != "" {
+		m.regex, err = regexp.Compile(cfg.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex: %s", err)
+		}
+	}
+	if cfg.Not != nil {
+		m.not, err = newValueMatcher(cfg.Not)
+		if err != nil {
+			return nil, fmt.Errorf("not: %s", err)
+		}
+	}
+
+	return m, nil
+}
+
+// String describes what is expected, e.g. "to match ^Bearer .+".
+func (m *valueMatcher) String() string {
+	switch {
+	case m.Absent:
+		return "to be absent"
+	case m.not != nil:
+		return "not " + m.not.String()
+	}
+
+	var parts []string
+	if m.Equal != "" {
+		parts = append(parts, "to be "+m.Equal)
+	}
+	if m.regex != nil {
+		parts = append(parts, "to match "+m.regex.String())
+	}
+	if len(parts) == 0 {
+		return "to be present"
+	}
+	return strings.Join(parts, " and ")
+}
+
+// match reports whether the values match, present is false when the header or
+// query string was not sent.
+func (m *valueMatcher) match(values []string, present bool) bool {
+	if m.Absent {
+		return !present
+	}
+	if m.not != nil {
+		return !m.not.match(values, present)
+	}
+	if !present {
+		return false
+	}
+	if m.Equal != "" && !containsFold(values, m.Equal) {
+		return false
+	}
+	if m.regex != nil {
+		found := false
+		for _, v := range values {
+			if m.regex.MatchString(v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// pathParamTypes are the types that can be used in path parameters, e.g. {id:int}.
+// Anything else after the colon is used as regex, e.g. {code:[A-Z]{3}}.
+var pathParamTypes = map[string]string{
+	"int":   `-?[0-9]+`,
+	"uuid":  `[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`,
+	"alpha": `[a-zA-Z]+`,
+	"alnum": `[a-zA-Z0-9]+`,
+}
+
+var validPathParamName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// compilePathPattern converts a path like /users/{id:int}/orders/{orderId} to a regex
+// with one named group per parameter. Only the literal parts ignore case, the
+// expressions of the parameters are used as written.
+func compilePathPattern(pattern string) (*regexp.Regexp, error) {
+	var buf strings.Builder
+	buf.WriteString("^")
+
+	literal := func(s string) {
+		if s != "" {
+			fmt.Fprintf(&buf, "(?i:%s)", regexp.QuoteMeta(s))
+		}
+	}
+
+	for pattern != "" {
+		start := strings.IndexByte(pattern, '{')
+		if start < 0 {
+			literal(pattern)
+			break
+		}
+		literal(pattern[:start])
+
+		end := closingBrace(pattern, start)
+		if end < 0 {
+			return nil, fmt.Errorf("missing } in '%s'", pattern[start:])
+		}
+
+		param := pattern[start+1 : end]
+		name, expr := param, `[^/]+`
+		if i := strings.IndexByte(param, ':'); i >= 0 {
+			name, expr = param[:i], param[i+1:]
+			if t, ok := pathParamTypes[expr]; ok {
+				expr = t
+			}
+		}
+		if !validPathParamName.MatchString(name) {
+			return nil, fmt.Errorf("invalid parameter name '%s'", name)
+		}
+
+		fmt.Fprintf(&buf, "(?P<%s>%s)", name, expr)
+		pattern = pattern[end+1:]
+	}
+
+	buf.WriteString("$")
+	return regexp.Compile(buf.String())
+}
+
+// splitPathPattern splits a url with path parameters in path and query string,
+// question marks inside the parameters are part of the path.
+func splitPathPattern(pattern string) (string, string) {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '{':
+			end := closingBrace(pattern, i)
+			if end < 0 {
+				return pattern, ""
+			}
+			i = end
+		case '?':
+			return pattern[:i], pattern[i+1:]
+		}
+	}
+	return pattern, ""
+}
+
+// closingBrace returns the index of the } closing the { at start, or -1.
+func closingBrace(s string, start int) int {
+	depth := 0
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,72 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+
+	"github.com/guilherme-santos/stubserver"
+)
+
+// errHijackNotSupported is returned when a fault cannot take the connection, e.g.
+// with HTTP/2.
+var errHijackNotSupported = errors.New("the connection cannot be hijacked")
+
+// fireFault reports whether f must happen in this request.
+func fireFault(f *stubserver.ConfigFault, rnd *rand.Rand) bool {
+	if f == nil {
+		return false
+	}
+	if f.Probability == nil {
+		return true
+	}
+	return rnd.Float64() < *f.Probability
+}
+
+// fault takes the connection of w and makes it fail as f, statusCode and body
+// are the response that would be sent, w has its headers.
+func fault(w http.ResponseWriter, f *stubserver.ConfigFault, statusCode int, body []byte, rnd *rand.Rand) error {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return errHijackNotSupported
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	switch f.Type {
+	case stubserver.FaultReset:
+		// without linger Close sends RST instead of FIN
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			tcpConn.SetLinger(0)
+		}
+
+	case stubserver.FaultGarbage:
+		n := f.Bytes
+		if n == 0 {
+			n = stubserver.DefaultFaultGarbageBytes
+		}
+		// not rnd.Read, it keeps state outside of the locked source
+		garbage := make([]byte, n)
+		for i := range garbage {
+			garbage[i] = byte(rnd.Intn(256))
+		}
+		rw.Write(garbage)
+
+	case stubserver.FaultTruncate:
+		n := f.Bytes
+		if n > len(body) {
+			n = len(body)
+		}
+		fmt.Fprintf(rw, "HTTP/1.1 %03d %s\r\n", statusCode, http.StatusText(statusCode))
+		w.Header().Write(rw)
+		rw.WriteString("\r\n")
+		rw.Write(body[:n])
+	}
+
+	return rw.Flush()
+}
@@ -0,0 +1,187 @@
+package http_test
+
+import (
+	"io/ioutil"
+	gohttp "net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/guilherme-santos/stubserver"
+	"github.com/guilherme-santos/stubserver/http"
+	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestGeneric_Fault(t *testing.T) {
+	var cfg stubserver.Config
+	err := yaml.Unmarshal([]byte(`
+endpoints:
+  - url: /reset
+    response:
+      data: abcdef
+      fault: reset
+  - url: /empty
+    response:
+      data: abcdef
+      fault: empty
+  - url: /garbage
+    response:
+      data: abcdef
+      fault:
+        type: garbage
+        bytes: 16
+  - url: /truncate
+    response:
+      headers:
+        Content-Type: text/plain
+      data: abcdef
+      fault:
+        type: truncate
+        bytes: 3
+  - url: /never
+    response:
+      data: abcdef
+      fault:
+        type: reset
+        probability: 0
+`), &cfg)
+	assert.NoError(t, err)
+
+	server := httptest.NewServer(gohttp.HandlerFunc(http.NewHandler(cfg).Generic))
+	defer server.Close()
+
+	client := &gohttp.Client{
+		Transport: &gohttp.Transport{DisableKeepAlives: true},
+	}
+
+	for _, url := range []string{"/reset", "/empty", "/garbage"} {
+		resp, err := client.Get(server.URL + url)
+		if assert.Error(t, err, url) {
+			continue
+		}
+		resp.Body.Close()
+	}
+
+	resp, err := client.Get(server.URL + "/truncate")
+	if assert.NoError(t, err) {
+		assert.Equal(t, gohttp.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+		assert.Equal(t, "6", resp.Header.Get("Content-Length"))
+
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Error(t, err)
+		assert.Equal(t, "abc", string(body))
+	}
+
+	resp, err = client.Get(server.URL + "/never")
+	if assert.NoError(t, err) {
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.NoError(t, err)
+		assert.Equal(t, "abcdef", string(body))
+	}
+}
+
+func TestGeneric_FaultWithoutHijack(t *testing.T) {
+	h := http.NewHandler(stubserver.Config{
+		Endpoints: []stubserver.ConfigRequest{
+			{
+				ID: "users",
+				Response: stubserver.ConfigResponse{
+					Data:  "[]",
+					Fault: &stubserver.ConfigFault{Type: stubserver.FaultReset},
+				},
+			},
+		},
+	})
+
+	w := httptest.NewRecorder()
+	h.Generic(w, httptest.NewRequest(gohttp.MethodGet, "/users", nil))
+
+	assert.Equal(t, gohttp.StatusInternalServerError, w.Code)
+	assert.JSONEq(t, `{"error": "fault_error", "message": "endpoint users: fault reset: the connection cannot be hijacked"}`, w.Body.String())
+}
+
+func TestSetConfig_InvalidFault(t *testing.T) {
+	probability := 1.5
+
+	tests := []struct {
+		fault    stubserver.ConfigFault
+		expected string
+	}{
+		{stubserver.ConfigFault{Type: "explode"}, "endpoint #0: fault: type must be reset, empty, garbage or truncate"},
+		{stubserver.ConfigFault{Type: stubserver.FaultReset, Probability: &probability}, "endpoint #0: fault: probability must be between 0 and 1"},
+	}
+
+	for _, test := range tests {
+		fault := test.fault
+		h := http.NewHandler(stubserver.Config{})
+		err := h.SetConfig(stubserver.Config{
+			Endpoints: []stubserver.ConfigRequest{
+				{Response: stubserver.ConfigResponse{Data: "[]", Fault: &fault}},
+			},
+		})
+		if assert.Error(t, err, test.expected) {
+			assert.Equal(t, test.expected, err.Error())
+		}
+	}
+}
+
+func TestConfigFault_UnmarshalYAML(t *testing.T) {
+	tests := []struct {
+		yaml string
+		err  string
+	}{
+		{`Reset`, ""},
+		{`{type: truncate, bytes: 10, probability: 0.5}`, ""},
+		{`explode`, "UnmarshalYAML: fault: type must be reset, empty, garbage or truncate"},
+		{`{type: garbage, bytes: -1}`, "UnmarshalYAML: fault: bytes cannot be negative"},
+		{`{type: reset, probability: 1.5}`, "UnmarshalYAML: fault: probability must be between 0 and 1"},
+	}
+
+	for _, test := range tests {
+		var fault stubserver.ConfigFault
+		err := yaml.Unmarshal([]byte(test.yaml), &fault)
+		if test.err == "" {
+			assert.NoError(t, err, test.yaml)
+			continue
+		}
+		if assert.Error(t, err, test.yaml) {
+			assert.Equal(t, test.err, err.Error(), test.yaml)
+		}
+	}
+}
+
+func TestGeneric_FaultConcurrent(t *testing.T) {
+	h := http.NewHandler(stubserver.Config{
+		Endpoints: []stubserver.ConfigRequest{
+			{
+				Response: stubserver.ConfigResponse{
+					Data:  "abcdef",
+					Fault: &stubserver.ConfigFault{Type: stubserver.FaultGarbage, Bytes: 1 << 16},
+				},
+			},
+		},
+	})
+
+	server := httptest.NewServer(gohttp.HandlerFunc(h.Generic))
+	defer server.Close()
+
+	client := &gohttp.Client{
+		Transport: &gohttp.Transport{DisableKeepAlives: true},
+	}
+
+	done := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			if resp, err := client.Get(server.URL); err == nil {
+				resp.Body.Close()
+			}
+		}()
+	}
+	for i := 0; i < 8; i++ {
+		<-done
+	}
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/guilherme-santos/stubserver"
 	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v2"
 )
 
 func TestFindEndpoint_DefaultEndpoint(t *testing.T) {
@@ -19,8 +20,8 @@ func TestFindEndpoint_DefaultEndpoint(t *testing.T) {
 
 	h := NewHandler(cfg)
 	reqURL, _ := url.ParseRequestURI("/default")
-	endpoint := h.findEndpoint("GET", reqURL, http.Header{})
-	assert.Equal(t, cfg.Endpoints[0], *endpoint)
+	endpoint, _, _ := h.findEndpoint(newRequest("GET", reqURL, http.Header{}, nil))
+	assertEndpoint(t, cfg.Endpoints[0], endpoint)
 }
 
 func TestFindEndpoint_DefaultMethodEndpoint(t *testing.T) {
@@ -33,8 +34,8 @@ func TestFindEndpoint_DefaultMethodEndpoint(t *testing.T) {
 
 	h := NewHandler(cfg)
 	reqURL, _ := url.ParseRequestURI("/default")
-	endpoint := h.findEndpoint("POST", reqURL, http.Header{})
-	assert.Equal(t, cfg.Endpoints[1], *endpoint)
+	endpoint, _, _ := h.findEndpoint(newRequest("POST", reqURL, http.Header{}, nil))
+	assertEndpoint(t, cfg.Endpoints[1], endpoint)
 }
 
 func TestFindEndpoint_BetterMatch(t *testing.T) {
@@ -47,8 +48,8 @@ func TestFindEndpoint_BetterMatch(t *testing.T) {
 
 	h := NewHandler(cfg)
 	reqURL, _ := url.ParseRequestURI("/users/1")
-	endpoint := h.findEndpoint("GET", reqURL, http.Header{})
-	assert.Equal(t, cfg.Endpoints[1], *endpoint)
+	endpoint, _, _ := h.findEndpoint(newRequest("GET", reqURL, http.Header{}, nil))
+	assertEndpoint(t, cfg.Endpoints[1], endpoint)
 }
 
 func TestFindEndpoint_WithoutQueryString(t *testing.T) {
@@ -61,8 +62,8 @@ func TestFindEndpoint_WithoutQueryString(t *testing.T) {
 
 	h := NewHandler(cfg)
 	reqURL, _ := url.ParseRequestURI("/users/1")
-	endpoint := h.findEndpoint("GET", reqURL, http.Header{})
-	assert.Equal(t, cfg.Endpoints[0], *endpoint)
+	endpoint, _, _ := h.findEndpoint(newRequest("GET", reqURL, http.Header{}, nil))
+	assertEndpoint(t, cfg.Endpoints[0], endpoint)
 }
 
 func TestFindEndpoint_WithQueryString(t *testing.T) {
@@ -75,8 +76,8 @@ func TestFindEndpoint_WithQueryString(t *testing.T) {
 
 	h := NewHandler(cfg)
 	reqURL, _ := url.ParseRequestURI("/users/1?field=address")
-	endpoint := h.findEndpoint("GET", reqURL, http.Header{})
-	assert.Equal(t, cfg.Endpoints[1], *endpoint)
+	endpoint, _, _ := h.findEndpoint(newRequest("GET", reqURL, http.Header{}, nil))
+	assertEndpoint(t, cfg.Endpoints[1], endpoint)
 }
 
 func TestFindEndpoint_WithNonMatchQueryString(t *testing.T) {
@@ -89,8 +90,8 @@ func TestFindEndpoint_WithNonMatchQueryString(t *testing.T) {
 
 	h := NewHandler(cfg)
 	reqURL, _ := url.ParseRequestURI("/users/1?field=company")
-	endpoint := h.findEndpoint("GET", reqURL, http.Header{})
-	assert.Equal(t, cfg.Endpoints[0], *endpoint)
+	endpoint, _, _ := h.findEndpoint(newRequest("GET", reqURL, http.Header{}, nil))
+	assertEndpoint(t, cfg.Endpoints[0], endpoint)
 }
 
 func TestFindEndpoint_WithQueryStringWithoutValue(t *testing.T) {
@@ -103,8 +104,8 @@ func TestFindEndpoint_WithQueryStringWithoutValue(t *testing.T) {
 
 	h := NewHandler(cfg)
 	reqURL, _ := url.ParseRequestURI("/users/1?field=address")
-	endpoint := h.findEndpoint("GET", reqURL, http.Header{})
-	assert.Equal(t, cfg.Endpoints[1], *endpoint)
+	endpoint, _, _ := h.findEndpoint(newRequest("GET", reqURL, http.Header{}, nil))
+	assertEndpoint(t, cfg.Endpoints[1], endpoint)
 }
 
 func TestFindEndpoint_Header(t *testing.T) {
@@ -119,16 +120,16 @@ func TestFindEndpoint_Header(t *testing.T) {
 	reqURL, _ := url.ParseRequestURI("/users/1")
 
 	header := http.Header{}
-	endpoint := h.findEndpoint("GET", reqURL, header)
-	assert.Equal(t, cfg.Endpoints[0], *endpoint)
+	endpoint, _, _ := h.findEndpoint(newRequest("GET", reqURL, header, nil))
+	assertEndpoint(t, cfg.Endpoints[0], endpoint)
 
 	header = http.Header{"X-Version": []string{"1.0.0"}}
-	endpoint = h.findEndpoint("GET", reqURL, header)
-	assert.Equal(t, cfg.Endpoints[0], *endpoint)
+	endpoint, _, _ = h.findEndpoint(newRequest("GET", reqURL, header, nil))
+	assertEndpoint(t, cfg.Endpoints[0], endpoint)
 
 	header = http.Header{"X-Version": []string{"2.0.0"}}
-	endpoint = h.findEndpoint("GET", reqURL, header)
-	assert.Equal(t, cfg.Endpoints[1], *endpoint)
+	endpoint, _, _ = h.findEndpoint(newRequest("GET", reqURL, header, nil))
+	assertEndpoint(t, cfg.Endpoints[1], endpoint)
 }
 
 func TestFindEndpoint_RegexURLMatch(t *testing.T) {
@@ -141,8 +142,8 @@ func TestFindEndpoint_RegexURLMatch(t *testing.T) {
 
 	h := NewHandler(cfg)
 	reqURL, _ := url.ParseRequestURI("/users/5")
-	endpoint := h.findEndpoint("GET", reqURL, http.Header{})
-	assert.Equal(t, cfg.Endpoints[1], *endpoint)
+	endpoint, _, _ := h.findEndpoint(newRequest("GET", reqURL, http.Header{}, nil))
+	assertEndpoint(t, cfg.Endpoints[1], endpoint)
 }
 
 func TestFindEndpoint_RegexURLDontMatch(t *testing.T) {
@@ -155,6 +156,260 @@ func TestFindEndpoint_RegexURLDontMatch(t *testing.T) {
 
 	h := NewHandler(cfg)
 	reqURL, _ := url.ParseRequestURI("/users/10")
-	endpoint := h.findEndpoint("GET", reqURL, http.Header{})
-	assert.Equal(t, cfg.Endpoints[0], *endpoint)
+	endpoint, _, _ := h.findEndpoint(newRequest("GET", reqURL, http.Header{}, nil))
+	assertEndpoint(t, cfg.Endpoints[0], endpoint)
+}
+
+// assertEndpoint compares the endpoint found with the one expected from the config,
+// ignoring the ID generated when config was loaded.
+func assertEndpoint(t *testing.T, expected stubserver.ConfigRequest, actual *endpoint) {
+	if assert.NotNil(t, actual) {
+		assert.NotEmpty(t, actual.ID)
+		expected.ID = actual.ID
+		assert.Equal(t, expected, actual.ConfigRequest)
+	}
+}
+
+func TestFindEndpoint_PathParams(t *testing.T) {
+	cfg := stubserver.Config{
+		Endpoints: []stubserver.ConfigRequest{
+			{URL: "/users", Method: "GET"},
+			{URL: "/users/{id:int}/orders/{orderId}", Method: "GET"},
+			{URL: "/users/{name:alpha}", Method: "GET"},
+			{URL: "/countries/{code:[A-Z]{2}}?lang=", Method: "GET"},
+		},
+	}
+
+	h := NewHandler(cfg)
+
+	reqURL, _ := url.ParseRequestURI("/users/10/orders/abc")
+	endpoint, _, _ := h.findEndpoint(newRequest("GET", reqURL, http.Header{}, nil))
+	assertEndpoint(t, cfg.Endpoints[1], endpoint)
+	assert.Equal(t, map[string]string{"id": "10", "orderId": "abc"}, endpoint.params(reqURL))
+
+	reqURL, _ = url.ParseRequestURI("/users/guilherme")
+	endpoint, _, _ = h.findEndpoint(newRequest("GET", reqURL, http.Header{}, nil))
+	assertEndpoint(t, cfg.Endpoints[2], endpoint)
+
+	reqURL, _ = url.ParseRequestURI("/users/abc/orders/abc")
+	endpoint, _, _ = h.findEndpoint(newRequest("GET", reqURL, http.Header{}, nil))
+	assertEndpoint(t, cfg.Endpoints[0], endpoint)
+
+	reqURL, _ = url.ParseRequestURI("/countries/DE?lang=en")
+	endpoint, _, _ = h.findEndpoint(newRequest("GET", reqURL, http.Header{}, nil))
+	assertEndpoint(t, cfg.Endpoints[3], endpoint)
+	assert.Equal(t, map[string]string{"code": "DE"}, endpoint.params(reqURL))
+
+	reqURL, _ = url.ParseRequestURI("/COUNTRIES/DE?lang=en")
+	endpoint, _, _ = h.findEndpoint(newRequest("GET", reqURL, http.Header{}, nil))
+	assertEndpoint(t, cfg.Endpoints[3], endpoint)
+
+	// the expression of the parameter keeps its case
+	reqURL, _ = url.ParseRequestURI("/countries/de?lang=en")
+	endpoint, _, _ = h.findEndpoint(newRequest("GET", reqURL, http.Header{}, nil))
+	assertEndpoint(t, cfg.Endpoints[0], endpoint)
+}
+
+func TestFindEndpoint_RegexNamedGroups(t *testing.T) {
+	cfg := stubserver.Config{
+		Endpoints: []stubserver.ConfigRequest{
+			{URL: "~/users/(?P<id>[0-9]+)", Method: "GET"},
+		},
+	}
+
+	h := NewHandler(cfg)
+	reqURL, _ := url.ParseRequestURI("/users/5")
+	endpoint, _, _ := h.findEndpoint(newRequest("GET", reqURL, http.Header{}, nil))
+	assert.Equal(t, map[string]string{"id": "5"}, endpoint.params(reqURL))
+}
+
+func TestFindEndpoint_Body(t *testing.T) {
+	var cfg stubserver.Config
+	err := yaml.Unmarshal([]byte(`
+endpoints:
+  - url: /payments
+    method: POST
+    body:
+      json: {"amount": 100, "currency": "EUR"}
+    response: exact
+  - url: /payments
+    method: POST
+    body:
+      partialjson: {"customer": {"tags": ["vip"]}}
+    response: partial
+  - url: /payments
+    method: POST
+    body:
+      jsonpath:
+        - path: $.amount
+          equals: 50
+        - path: $.currency
+          regex: ^(EUR|USD)$
+        - path: $.items[*].sku
+          exists: true
+        - path: $.coupon
+          exists: false
+    response: jsonpath
+  - url: /payments
+    method: POST
+    response: default
+`), &cfg)
+	assert.NoError(t, err)
+
+	h := NewHandler(cfg)
+	reqURL, _ := url.ParseRequestURI("/payments")
+
+	tests := map[string]int{
+		`{"amount": 100, "currency": "EUR"}`:                                      0,
+		`{"amount": 100, "currency": "EUR", "extra": true}`:                       3,
+		`{"amount": 1, "customer": {"id": 1, "tags": ["new", "vip"]}}`:            1,
+		`{"amount": 50, "currency": "USD", "items": [{"sku": "a"}]}`:              2,
+		`{"amount": 50, "currency": "BRL", "items": [{"sku": "a"}]}`:              3,
+		`{"amount": 50, "currency": "USD", "items": [{"id": 1}]}`:                 3,
+		`{"amount": 50, "currency": "USD", "items": [{"sku": "a"}], "coupon": 1}`: 3,
+		`not json`: 3,
+	}
+
+	for body, expected := range tests {
+		endpoint, _, _ := h.findEndpoint(newRequest("POST", reqURL, http.Header{}, []byte(body)))
+		assert.Equal(t, cfg.Endpoints[expected].Response.Data, endpoint.Response.Data, body)
+	}
+}
+
+func TestFindEndpoint_HeaderAndQueryOperators(t *testing.T) {
+	var cfg stubserver.Config
+	err := yaml.Unmarshal([]byte(`
+endpoints:
+  - url: /users
+    method: GET
+    headers:
+      Authorization: {regex: ^Bearer .+}
+      X-Debug: {absent: true}
+    query:
+      version: {not: 1}
+    response: authorized
+  - url: /users
+    method: GET
+    headers:
+      Accept: application/xml
+    query:
+      version: {not: {regex: "^[0-9]+$"}}
+    response: xml
+  - url: /users
+    method: GET
+    response: default
+`), &cfg)
+	assert.NoError(t, err)
+
+	h := NewHandler(cfg)
+
+	tests := []struct {
+		url      string
+		header   http.Header
+		expected int
+	}{
+		{"/users", http.Header{"Authorization": {"Bearer abc"}}, 0},
+		{"/users?version=2", http.Header{"Authorization": {"Basic abc", "Bearer abc"}}, 0},
+		{"/users?version=1", http.Header{"Authorization": {"Bearer abc"}}, 2},
+		{"/users", http.Header{"Authorization": {"Bearer abc"}, "X-Debug": {"1"}}, 2},
+		{"/users", http.Header{"Authorization": {"Basic abc"}}, 2},
+		{"/users?version=beta", http.Header{"Accept": {"text/html", "application/xml"}}, 1},
+		{"/users?version=1&version=beta", http.Header{"Accept": {"application/xml"}}, 2},
+	}
+
+	for _, tt := range tests {
+		reqURL, _ := url.ParseRequestURI(tt.url)
+		endpoint, _, _ := h.findEndpoint(newRequest("GET", reqURL, tt.header, nil))
+		assert.Equal(t, cfg.Endpoints[tt.expected].Response.Data, endpoint.Response.Data, "%s %v", tt.url, tt.header)
+	}
+}
+
+func TestFindEndpoint_Priority(t *testing.T) {
+	cfg := stubserver.Config{
+		Endpoints: []stubserver.ConfigRequest{
+			{URL: "/users/1", Method: "GET"},
+			{URL: "~/users/.*", Method: "GET", Priority: 1},
+			{URL: "/users/{id}", Method: "GET"},
+		},
+	}
+
+	h := NewHandler(cfg)
+
+	reqURL, _ := url.ParseRequestURI("/users/1")
+	endpoint, _, _ := h.findEndpoint(newRequest("GET", reqURL, http.Header{}, nil))
+	assertEndpoint(t, cfg.Endpoints[1], endpoint)
+
+	cfg.Endpoints[1].Priority = 0
+	h = NewHandler(cfg)
+	endpoint, _, _ = h.findEndpoint(newRequest("GET", reqURL, http.Header{}, nil))
+	assertEndpoint(t, cfg.Endpoints[0], endpoint)
+
+	reqURL, _ = url.ParseRequestURI("/users/2")
+	endpoint, _, _ = h.findEndpoint(newRequest("GET", reqURL, http.Header{}, nil))
+	assertEndpoint(t, cfg.Endpoints[2], endpoint)
+}
+
+func TestFindEndpoint_Methods(t *testing.T) {
+	var cfg stubserver.Config
+	err := yaml.Unmarshal([]byte(`
+endpoints:
+  - url: /users
+    method: [PUT, PATCH]
+  - url: /users
+    method: any
+  - url: /users
+    method: HEAD
+  - url: /companies
+    method: GET
+`), &cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"PUT", "PATCH"}, cfg.Endpoints[0].Methods)
+
+	h := NewHandler(cfg)
+
+	reqURL, _ := url.ParseRequestURI("/users")
+	endpoint, matched, _ := h.findEndpoint(newRequest("PATCH", reqURL, http.Header{}, nil))
+	assert.True(t, matched)
+	assertEndpoint(t, cfg.Endpoints[0], endpoint)
+
+	endpoint, _, _ = h.findEndpoint(newRequest("OPTIONS", reqURL, http.Header{}, nil))
+	assertEndpoint(t, cfg.Endpoints[1], endpoint)
+
+	endpoint, _, _ = h.findEndpoint(newRequest("HEAD", reqURL, http.Header{}, nil))
+	assertEndpoint(t, cfg.Endpoints[2], endpoint)
+
+	// without HEAD endpoint it's answered by the GET one
+	reqURL, _ = url.ParseRequestURI("/companies")
+	endpoint, matched, _ = h.findEndpoint(newRequest("HEAD", reqURL, http.Header{}, nil))
+	assert.True(t, matched)
+	assertEndpoint(t, cfg.Endpoints[3], endpoint)
+}
+
+func TestExplain(t *testing.T) {
+	cfg := stubserver.Config{
+		Endpoints: []stubserver.ConfigRequest{
+			{ID: "list", URL: "/users", Method: "GET"},
+			{ID: "create", URL: "/users", Method: "POST", Headers: http.Header{"X-Version": []string{"2"}}},
+			{ID: "delete", URL: "/users/{id}", Method: "DELETE"},
+		},
+	}
+
+	h := NewHandler(cfg)
+	reqURL, _ := url.ParseRequestURI("/users")
+
+	report := h.explain(newRequest("POST", reqURL, http.Header{"X-Version": []string{"1"}}, nil))
+	assert.False(t, report.Matched)
+	if assert.Len(t, report.Candidates, 3) {
+		assert.Equal(t, "create", report.Candidates[0].ID)
+		assert.Equal(t, []string{"header: expected X-Version to be 2 but got 1"}, report.Candidates[0].Failed)
+		assert.Equal(t, "list", report.Candidates[1].ID)
+		assert.Equal(t, []string{"method: expected GET but got POST"}, report.Candidates[1].Failed)
+		assert.Equal(t, "delete", report.Candidates[2].ID)
+		assert.Len(t, report.Candidates[2].Failed, 2)
+	}
+
+	report = h.explain(newRequest("GET", reqURL, http.Header{}, nil))
+	assert.True(t, report.Matched)
+	assert.Equal(t, "list", report.EndpointID)
+	assert.Equal(t, "list", report.Candidates[0].ID)
 }
@@ -0,0 +1,341 @@
+package http
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// newTemplateFuncs returns the functions available in templates, the random ones
+// use rnd. Values given to the math functions can be numbers or strings.
+//
+//	jsonEscape v           escapes v to be inside a JSON string, e.g. {"name": "{{jsonEscape .Query.name}}"}
+//	xmlEscape v            escapes v to be inside a XML element or attribute
+//	urlEscape v            escapes v to be a query string param or a path segment
+//	toJson v               v encoded as JSON, e.g. {{toJson .JSON.user}}
+//	uuid                   random UUID v4
+//	now                    current time, e.g. {{now.Unix}} or {{now.Format "2006-01-02"}}
+//	timestamp format       current time in format: rfc3339, rfc1123, unix, unixmilli or a Go layout
+//	randomInt min max      random integer between min and max, both included
+//	randomFloat min max    random float between min (included) and max
+//	randomString n         random alphanumeric string with n characters
+//	base64Encode v         v encoded as standard base64, base64Decode decodes it
+//	hexEncode v            v encoded as hexadecimal, hexDecode decodes it
+//	add, sub, mul, div a b arithmetic, e.g. {{add .Params.id 1}}
+//	mod a b                remainder of the integer division
+//	default d v            v or d when v is empty, e.g. {{.Query.page | default 1}}
+//	upper, lower, title v  v in upper, lower or title case
+//	trim v                 v without leading and trailing spaces
+//	replace old new v      v with all old replaced by new
+//	split sep v, join sep v  split v in a list or join a list in a string
+func newTemplateFuncs(rnd *rand.Rand) map[string]interface{} {
+	return map[string]interface{}{
+		"jsonEscape": jsonEscape,
+		"xmlEscape":  xmlEscape,
+		"urlEscape":  url.QueryEscape,
+		"toJson":     toJSON,
+
+		"uuid":         func() string { return randomUUID(rnd) },
+		"now":          time.Now,
+		"timestamp":    timestamp,
+		"randomInt":    func(min, max interface{}) (int64, error) { return randomInt(rnd, min, max) },
+		"randomFloat":  func(min, max interface{}) (float64, error) { return randomFloat(rnd, min, max) },
+		"randomString": func(n int) string { return randomString(rnd, n) },
+
+		"base64Encode": func(v interface{}) string { return base64.StdEncoding.EncodeToString([]byte(fmt.Sprint(v))) },
+		"base64Decode": func(v interface{}) (string, error) {
+			b, err := base64.StdEncoding.DecodeString(fmt.Sprint(v))
+			return string(b), err
+		},
+		"hexEncode": func(v interface{}) string { return hex.EncodeToString([]byte(fmt.Sprint(v))) },
+		"hexDecode": func(v interface{}) (string, error) {
+			b, err := hex.DecodeString(fmt.Sprint(v))
+			return string(b), err
+		},
+
+		"add": add,
+		"sub": sub,
+		"mul": mul,
+		"div": div,
+		"mod": mod,
+
+		"default": defaultValue,
+		"upper":   func(v interface{}) string { return strings.ToUpper(fmt.Sprint(v)) },
+		"lower":   func(v interface{}) string { return strings.ToLower(fmt.Sprint(v)) },
+		"title":   func(v interface{}) string { return strings.Title(fmt.Sprint(v)) },
+		"trim":    func(v interface{}) string { return strings.TrimSpace(fmt.Sprint(v)) },
+		"replace": func(old, new string, v interface{}) string { return strings.Replace(fmt.Sprint(v), old, new, -1) },
+		"split":   func(sep string, v interface{}) []string { return strings.Split(fmt.Sprint(v), sep) },
+		"join":    join,
+	}
+}
+
+// newRand returns a rand safe for concurrent use.
+func newRand(seed int64) *rand.Rand {
+	return rand.New(&lockedSource{src: rand.NewSource(seed)})
+}
+
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.src.Seed(seed)
+}
+
+func jsonEscape(v interface{}) (string, error) {
+	b, err := marshalJSON(fmt.Sprint(v))
+	if err != nil {
+		return "", err
+	}
+	// without the quotes of the string
+	return b[1 : len(b)-1], nil
+}
+
+func toJSON(v interface{}) (string, error) {
+	return marshalJSON(v)
+}
+
+// marshalJSON encodes v without escaping HTML characters.
+func marshalJSON(v interface{}) (string, error) {
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return "", err
+	}
+	// without the new line added by Encode
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+func xmlEscape(v interface{}) (string, error) {
+	buf := new(bytes.Buffer)
+	if err := xml.EscapeText(buf, []byte(fmt.Sprint(v))); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func timestamp(format string) string {
+	now := time.Now()
+
+	switch strings.ToLower(format) {
+	case "", "rfc3339":
+		return now.Format(time.RFC3339)
+	case "rfc1123":
+		return now.UTC().Format(http.TimeFormat)
+	case "unix":
+		return strconv.FormatInt(now.Unix(), 10)
+	case "unixmilli":
+		return strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10)
+	}
+	return now.Format(format)
+}
+
+func randomUUID(rnd *rand.Rand) string {
+	var b [16]byte
+	for i := range b {
+		b[i] = byte(rnd.Intn(256))
+	}
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // variant RFC 4122
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func randomInt(rnd *rand.Rand, min, max interface{}) (int64, error) {
+	lo, err := toFloat(min)
+	if err != nil {
+		return 0, err
+	}
+	hi, err := toFloat(max)
+	if err != nil {
+		return 0, err
+	}
+	if hi < lo {
+		return 0, fmt.Errorf("max %v is less than min %v", max, min)
+	}
+	return int64(lo) + rnd.Int63n(int64(hi)-int64(lo)+1), nil
+}
+
+func randomFloat(rnd *rand.Rand, min, max interface{}) (float64, error) {
+	lo, err := toFloat(min)
+	if err != nil {
+		return 0, err
+	}
+	hi, err := toFloat(max)
+	if err != nil {
+		return 0, err
+	}
+	if hi < lo {
+		return 0, fmt.Errorf("max %v is less than min %v", max, min)
+	}
+	return lo + rnd.Float64()*(hi-lo), nil
+}
+
+const alphanumeric = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+func randomString(rnd *rand.Rand, n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = alphanumeric[rnd.Intn(len(alphanumeric))]
+	}
+	return string(b)
+}
+
+// toFloat converts numbers and strings with numbers, the values in templates come
+// from JSON (float64), paths and query strings (string) or the template itself (int).
+func toFloat(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case int:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case json.Number:
+		return n.Float64()
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		if err != nil {
+			return 0, fmt.Errorf("'%s' is not a number", n)
+		}
+		return f, nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	}
+	return 0, fmt.Errorf("%v is not a number", v)
+}
+
+// number is the result of the math functions, it's printed without exponent,
+// e.g. 1000000 instead of 1e+06, to be valid in paths and JSON.
+type number float64
+
+func (n number) String() string {
+	return strconv.FormatFloat(float64(n), 'f', -1, 64)
+}
+
+func arithmetic(a, b interface{}, op func(x, y float64) float64) (number, error) {
+	x, err := toFloat(a)
+	if err != nil {
+		return 0, err
+	}
+	y, err := toFloat(b)
+	if err != nil {
+		return 0, err
+	}
+	return number(op(x, y)), nil
+}
+
+func add(a, b interface{}) (number, error) {
+	return arithmetic(a, b, func(x, y float64) float64 { return x + y })
+}
+
+func sub(a, b interface{}) (number, error) {
+	return arithmetic(a, b, func(x, y float64) float64 { return x - y })
+}
+
+func mul(a, b interface{}) (number, error) {
+	return arithmetic(a, b, func(x, y float64) float64 { return x * y })
+}
+
+func div(a, b interface{}) (number, error) {
+	y, err := toFloat(b)
+	if err != nil {
+		return 0, err
+	}
+	if y == 0 {
+		return 0, errors.New("division by zero")
+	}
+	return arithmetic(a, y, func(x, y float64) float64 { return x / y })
+}
+
+func mod(a, b interface{}) (int64, error) {
+	x, err := toFloat(a)
+	if err != nil {
+		return 0, err
+	}
+	y, err := toFloat(b)
+	if err != nil {
+		return 0, err
+	}
+	if int64(y) == 0 {
+		return 0, errors.New("division by zero")
+	}
+	return int64(math.Trunc(x)) % int64(y), nil
+}
+
+// defaultValue returns v, or d when v is nil, an empty string, zero, false or an
+// empty collection.
+func defaultValue(d, v interface{}) interface{} {
+	if v == nil {
+		return d
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		if rv.Len() == 0 {
+			return d
+		}
+	case reflect.Bool:
+		if !rv.Bool() {
+			return d
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if rv.Int() == 0 {
+			return d
+		}
+	case reflect.Float32, reflect.Float64:
+		if rv.Float() == 0 {
+			return d
+		}
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return d
+		}
+	}
+	return v
+}
+
+func join(sep string, v interface{}) (string, error) {
+	switch list := v.(type) {
+	case []string:
+		return strings.Join(list, sep), nil
+	case []interface{}:
+		s := make([]string, len(list))
+		for i, item := range list {
+			s[i] = fmt.Sprint(item)
+		}
+		return strings.Join(s, sep), nil
+	}
+	return "", fmt.Errorf("%v is not a list", v)
+}
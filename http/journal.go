@@ -0,0 +1,178 @@
+package http
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultJournalSize is how many requests are kept by the journal of a new handler.
+const DefaultJournalSize = 1000
+
+// JournalEntry is a request received by the stubs and the response sent to it.
+type JournalEntry struct {
+	Time    time.Time   `json:"time"`
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Headers http.Header `json:"headers"`
+	Body    string      `json:"body"`
+	// Matched is true when the request matched all criteria of the endpoint,
+	// EndpointID is also set when a default endpoint was used.
+	Matched    bool   `json:"matched"`
+	EndpointID string `json:"endpoint_id,omitempty"`
+	StatusCode int    `json:"statuscode"`
+}
+
+// JournalFilter selects entries of the journal, zero values match everything.
+type JournalFilter struct {
+	Method string
+	// Path is compared with the path of the request, it can be a regex if starts with ~.
+	Path    string
+	Headers http.Header
+	Since   time.Time
+	Until   time.Time
+	Matched *bool
+}
+
+// Journal keeps the last requests received, when it's full the oldest entries
+// are discarded.
+type Journal struct {
+	mu      sync.RWMutex
+	entries []JournalEntry
+	next    int
+	full    bool
+}
+
+// NewJournal returns a journal keeping up to size entries, size 0 disables it.
+func NewJournal(size int) *Journal {
+	return &Journal{
+		entries: make([]JournalEntry, size),
+	}
+}
+
+func (j *Journal) Add(entry JournalEntry) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if len(j.entries) == 0 {
+		return
+	}
+
+	j.entries[j.next] = entry
+	j.next = (j.next + 1) % len(j.entries)
+	if j.next == 0 {
+		j.full = true
+	}
+}
+
+// Entries returns all entries from the oldest to the newest.
+func (j *Journal) Entries() []JournalEntry {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	if !j.full {
+		return append([]JournalEntry{}, j.entries[:j.next]...)
+	}
+
+	entries := make([]JournalEntry, 0, len(j.entries))
+	entries = append(entries, j.entries[j.next:]...)
+	return append(entries, j.entries[:j.next]...)
+}
+
+// Find returns the entries matching filter from the oldest to the newest.
+func (j *Journal) Find(filter JournalFilter) ([]JournalEntry, error) {
+	var pathRegex *regexp.Regexp
+	if strings.HasPrefix(filter.Path, "~") {
+		var err error
+		pathRegex, err = regexp.Compile(strings.TrimSpace(filter.Path[1:]))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	entries := []JournalEntry{}
+
+	for _, entry := range j.Entries() {
+		if filter.Method != "" && !strings.EqualFold(filter.Method, entry.Method) {
+			continue
+		}
+		if filter.Path != "" {
+			path := entry.URL
+			if i := strings.IndexAny(path, "?#"); i >= 0 {
+				path = path[:i]
+			}
+			if pathRegex != nil && !pathRegex.MatchString(path) {
+				continue
+			}
+			if pathRegex == nil && !strings.EqualFold(filter.Path, path) {
+				continue
+			}
+		}
+		if !filter.Since.IsZero() && entry.Time.Before(filter.Since) {
+			continue
+		}
+		if !filter.Until.IsZero() && entry.Time.After(filter.Until) {
+			continue
+		}
+		if filter.Matched != nil && *filter.Matched != entry.Matched {
+			continue
+		}
+		if !matchValues(filter.Headers, entry.Headers) {
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// Reset removes all entries.
+func (j *Journal) Reset() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.entries = make([]JournalEntry, len(j.entries))
+	j.next = 0
+	j.full = false
+}
+
+// statusRecorder keeps the status code sent to the client.
+type statusRecorder struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (r *statusRecorder) WriteHeader(statusCode int) {
+	if r.statusCode == 0 {
+		r.statusCode = statusCode
+	}
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.statusCode == 0 {
+		r.statusCode = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Flush sends the data written so far when the ResponseWriter supports it.
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack lets faults take the connection when the ResponseWriter supports it.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errHijackNotSupported
+	}
+	return hijacker.Hijack()
+}
@@ -0,0 +1,87 @@
+package http_test
+
+import (
+	"encoding/json"
+	gohttp "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/guilherme-santos/stubserver"
+	"github.com/guilherme-santos/stubserver/http"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournal_Bounded(t *testing.T) {
+	j := http.NewJournal(2)
+	j.Add(http.JournalEntry{URL: "/1"})
+	j.Add(http.JournalEntry{URL: "/2"})
+	j.Add(http.JournalEntry{URL: "/3"})
+
+	entries := j.Entries()
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "/2", entries[0].URL)
+		assert.Equal(t, "/3", entries[1].URL)
+	}
+
+	j.Reset()
+	assert.Empty(t, j.Entries())
+}
+
+func TestGeneric_Journal(t *testing.T) {
+	cfg := stubserver.Config{
+		Endpoints: []stubserver.ConfigRequest{
+			{ID: "create-user", URL: "/users", Method: "POST", Response: stubserver.ConfigResponse{StatusCode: gohttp.StatusCreated}},
+		},
+	}
+
+	h := http.NewHandler(cfg)
+
+	req := httptest.NewRequest(gohttp.MethodPost, "/users?notify=1", strings.NewReader(`{"name":"Guilherme"}`))
+	req.Header.Set("X-Version", "2")
+	h.Generic(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest(gohttp.MethodPost, "/companies", nil)
+	h.Generic(httptest.NewRecorder(), req)
+
+	entries := h.Journal.Entries()
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "POST", entries[0].Method)
+		assert.Equal(t, "/users?notify=1", entries[0].URL)
+		assert.Equal(t, "2", entries[0].Headers.Get("X-Version"))
+		assert.Equal(t, `{"name":"Guilherme"}`, entries[0].Body)
+		assert.Equal(t, "create-user", entries[0].EndpointID)
+		assert.True(t, entries[0].Matched)
+		assert.Equal(t, gohttp.StatusCreated, entries[0].StatusCode)
+		assert.False(t, entries[0].Time.IsZero())
+
+		assert.False(t, entries[1].Matched)
+	}
+
+	var resp struct {
+		Requests []http.JournalEntry
+	}
+
+	w := adminRequest(h, gohttp.MethodGet, "/__admin/requests?method=post&path=/users&header=X-Version:2", "")
+	assert.Equal(t, gohttp.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Len(t, resp.Requests, 1)
+
+	w = adminRequest(h, gohttp.MethodGet, "/__admin/requests?matched=false", "")
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	if assert.Len(t, resp.Requests, 1) {
+		assert.Equal(t, "/companies", resp.Requests[0].URL)
+	}
+
+	w = adminRequest(h, gohttp.MethodGet, "/__admin/requests?path=~^/(users|companies)$&since=2000-01-01T00:00:00Z", "")
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Len(t, resp.Requests, 2)
+
+	w = adminRequest(h, gohttp.MethodGet, "/__admin/requests?until=2000-01-01T00:00:00Z", "")
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Len(t, resp.Requests, 0)
+
+	w = adminRequest(h, gohttp.MethodDelete, "/__admin/requests", "")
+	assert.Equal(t, gohttp.StatusNoContent, w.Code)
+	assert.Empty(t, h.Journal.Entries())
+}
@@ -0,0 +1,112 @@
+package http
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonPath is a compiled subset of JSONPath: $ followed by fields (.name or ['name']),
+// array indexes ([0], negative counts from the end) and wildcards (.* or [*]).
+type jsonPath []jsonPathStep
+
+type jsonPathStep struct {
+	field    string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+func compileJSONPath(path string) (jsonPath, error) {
+	s := strings.TrimSpace(path)
+	if !strings.HasPrefix(s, "$") {
+		return nil, fmt.Errorf("jsonpath '%s' must start with $", path)
+	}
+	s = s[1:]
+
+	var steps jsonPath
+
+	for s != "" {
+		switch s[0] {
+		case '.':
+			s = s[1:]
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			name := s[:end]
+			if name == "" {
+				return nil, fmt.Errorf("jsonpath '%s' has an empty field", path)
+			}
+			if name == "*" {
+				steps = append(steps, jsonPathStep{wildcard: true})
+			} else {
+				steps = append(steps, jsonPathStep{field: name})
+			}
+			s = s[end:]
+		case '[':
+			end := strings.IndexByte(s, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("jsonpath '%s' is missing ]", path)
+			}
+			inside := strings.TrimSpace(s[1:end])
+			s = s[end+1:]
+
+			switch {
+			case inside == "*":
+				steps = append(steps, jsonPathStep{wildcard: true})
+			case len(inside) >= 2 && (inside[0] == '\'' || inside[0] == '"') && inside[len(inside)-1] == inside[0]:
+				steps = append(steps, jsonPathStep{field: inside[1 : len(inside)-1]})
+			default:
+				i, err := strconv.Atoi(inside)
+				if err != nil {
+					return nil, fmt.Errorf("jsonpath '%s' has an invalid index '%s'", path, inside)
+				}
+				steps = append(steps, jsonPathStep{index: i, isIndex: true})
+			}
+		default:
+			return nil, fmt.Errorf("jsonpath '%s' has an unexpected '%c'", path, s[0])
+		}
+	}
+
+	return steps, nil
+}
+
+// eval returns all values selected by the path in doc, doc is a value decoded by
+// encoding/json.
+func (p jsonPath) eval(doc interface{}) []interface{} {
+	values := []interface{}{doc}
+
+	for _, step := range p {
+		var next []interface{}
+
+		for _, v := range values {
+			switch v := v.(type) {
+			case map[string]interface{}:
+				if step.wildcard {
+					for _, item := range v {
+						next = append(next, item)
+					}
+				} else if item, ok := v[step.field]; ok && !step.isIndex {
+					next = append(next, item)
+				}
+			case []interface{}:
+				if step.wildcard {
+					next = append(next, v...)
+				} else if step.isIndex {
+					i := step.index
+					if i < 0 {
+						i += len(v)
+					}
+					if i >= 0 && i < len(v) {
+						next = append(next, v[i])
+					}
+				}
+			}
+		}
+
+		values = next
+	}
+
+	return values
+}
@@ -0,0 +1,284 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Weights of each criterion when scoring endpoints, the endpoint chosen is the one
+// matching all its criteria with the highest priority and then highest score.
+const (
+	scoreMethod    = 1
+	scorePath      = 4
+	scorePattern   = 3
+	scoreRegex     = 2
+	scoreValue     = 1 // each query string, header or body criterion
+	maxExplainSize = 5
+)
+
+// matchResult is how a request was compared with one endpoint.
+type matchResult struct {
+	endpoint *endpoint
+	score    int
+	failed   []string
+}
+
+func (r *matchResult) matched() bool {
+	return len(r.failed) == 0
+}
+
+func (r *matchResult) check(ok bool, score int, format string, args ...interface{}) {
+	if ok {
+		r.score += score
+		return
+	}
+	r.failed = append(r.failed, fmt.Sprintf(format, args...))
+}
+
+// match reports whether the request matches all criteria of the endpoint, empty
+// method or url match any request.
+func (e *endpoint) match(req *request) bool {
+	return e.evaluate(req).matched()
+}
+
+// evaluate compares the request with every criterion of the endpoint.
+func (e *endpoint) evaluate(req *request) *matchResult {
+	r := &matchResult{endpoint: e}
+
+	if e.methods != nil {
+		r.check(e.matchMethod(req.method), scoreMethod, "method: expected %s but got %s", strings.Join(e.methods, " or "), req.method)
+	}
+
+	reqQuery := req.url.Query()
+
+	switch {
+	case e.URL == "":
+		// any url
+	case e.urlRegex != nil:
+		r.check(e.urlRegex.MatchString(req.url.String()), scoreRegex, "url: %s doesn't match regex %s", req.url, e.urlRegex)
+	default:
+		score := scorePath
+		if e.pathRegex != nil {
+			score = scorePattern
+		}
+		r.check(e.matchPath(req.url), score, "path: %s doesn't match %s", req.url.Path, e.url.Path)
+
+		for _, k := range sortedKeys(e.query) {
+			ev := e.query[k]
+			r.check(matchValues(map[string][]string{k: ev}, reqQuery), scoreValue, "query string: expected %s=%s but got %s", k, ev[0], describeValues(reqQuery[k]))
+		}
+	}
+
+	for _, k := range sortedMatcherKeys(e.queryMatchers) {
+		values, ok := reqQuery[k]
+		r.check(e.queryMatchers[k].match(values, ok), scoreValue, "query string: %s %s but got %s", k, e.queryMatchers[k], describeValues(values))
+	}
+
+	for _, k := range sortedKeys(e.Headers) {
+		ev := e.Headers[k]
+		expected := "to be present"
+		if len(ev) > 0 && ev[0] != "" {
+			expected = "to be " + ev[0]
+		}
+		r.check(matchValues(map[string][]string{k: ev}, req.header), scoreValue, "header: expected %s %s but got %s", k, expected, describeValues(req.header[k]))
+	}
+	for _, k := range sortedMatcherKeys(e.headerMatchers) {
+		values, ok := req.header[k]
+		r.check(e.headerMatchers[k].match(values, ok), scoreValue, "header: %s %s but got %s", k, e.headerMatchers[k], describeValues(values))
+	}
+
+	if e.Body != nil {
+		doc, ok := req.json()
+		if !ok {
+			r.failed = append(r.failed, "body: it's not valid JSON")
+			return r
+		}
+
+		if e.Body.JSON != nil {
+			r.check(reflect.DeepEqual(e.Body.JSON, doc), scoreValue, "body: it's not equal to the JSON expected")
+		}
+		if e.Body.PartialJSON != nil {
+			r.check(containsJSON(doc, e.Body.PartialJSON), scoreValue, "body: it doesn't contain the JSON expected")
+		}
+		for _, p := range e.jsonPaths {
+			r.check(p.match(p.path.eval(doc)), scoreValue, "body: jsonpath %s doesn't match", p.Path)
+		}
+	}
+
+	return r
+}
+
+// findEndpoint returns the endpoint matching all criteria with the highest priority,
+// and then the highest score. When none matches, matched is false and the endpoint
+// is the upstream one or the fallback, without them it's nil in strict mode, otherwise
+// it's the first endpoint with the same method or the first endpoint. results are
+// the comparisons of req with every endpoint, to explain the choice.
+func (h *Handler) findEndpoint(req *request) (e *endpoint, matched bool, results []*matchResult) {
+	m, best, results := h.matchEndpoints(req)
+	if best == nil && strings.EqualFold(req.method, http.MethodHead) {
+		// HEAD is answered by the GET endpoint when there's none for it
+		getReq := *req
+		getReq.method = http.MethodGet
+		m, best, _ = h.matchEndpoints(&getReq)
+	}
+	if best != nil {
+		h.DebugLogger.Printf("%s %s: match endpoint=%s %s score=%d", req.method, req.url, best.endpoint.Method, best.endpoint.URL, best.score)
+		return best.endpoint, true, results
+	}
+
+	for _, r := range results {
+		h.DebugLogger.Printf("%s %s: doesn't match endpoint=%s %s: %s", req.method, req.url, r.endpoint.Method, r.endpoint.URL, strings.Join(r.failed, "; "))
+	}
+
+	if h.upstream(m) != nil {
+		return upstreamEndpoint, false, results
+	}
+	if m.fallback != nil {
+		return m.fallback, false, results
+	}
+	if m.strict || h.Strict {
+		return nil, false, results
+	}
+
+	for _, r := range results {
+		if r.endpoint.methods != nil && r.endpoint.matchMethod(req.method) {
+			return r.endpoint, false, results
+		}
+	}
+	if len(results) > 0 {
+		return results[0].endpoint, false, results
+	}
+	return nil, false, results
+}
+
+// matchEndpoints evaluates all endpoints of the current matcher, best is nil when
+// none matches.
+func (h *Handler) matchEndpoints(req *request) (m *matcher, best *matchResult, results []*matchResult) {
+	m = h.matcher()
+	results = make([]*matchResult, 0, len(m.endpoints))
+
+	for _, e := range m.endpoints {
+		results = append(results, e.evaluate(req))
+	}
+
+	return m, bestResult(results), results
+}
+
+// bestResult returns the result matching with the highest priority and then the
+// highest score, or nil when none matches.
+func bestResult(results []*matchResult) (best *matchResult) {
+	for _, r := range results {
+		if !r.matched() {
+			continue
+		}
+		if best == nil || r.endpoint.Priority > best.endpoint.Priority ||
+			(r.endpoint.Priority == best.endpoint.Priority && r.score > best.score) {
+			best = r
+		}
+	}
+	return best
+}
+
+// ExplainHeader can be sent in a request to receive in the response, in the same
+// header, the explain report of the request as JSON.
+const ExplainHeader = "X-Stubserver-Explain"
+
+// explainReport tells which endpoint was chosen for a request and why the closest
+// endpoints didn't match.
+type explainReport struct {
+	Matched    bool               `json:"matched"`
+	EndpointID string             `json:"endpoint_id,omitempty"`
+	Candidates []explainCandidate `json:"candidates"`
+}
+
+type explainCandidate struct {
+	ID       string   `json:"id"`
+	Method   string   `json:"method"`
+	Methods  []string `json:"methods,omitempty"`
+	URL      string   `json:"url"`
+	Priority int      `json:"priority"`
+	Score    int      `json:"score"`
+	Matched  bool     `json:"matched"`
+	Failed   []string `json:"failed,omitempty"`
+}
+
+// explain returns the report of req with the closest endpoints first: the ones
+// with less criteria failing and then higher score.
+func (h *Handler) explain(req *request) explainReport {
+	_, _, results := h.matchEndpoints(req)
+	return newExplainReport(results)
+}
+
+// newExplainReport returns the report of the results of a request, they're not
+// changed.
+func newExplainReport(results []*matchResult) explainReport {
+	best := bestResult(results)
+	results = append([]*matchResult{}, results...)
+
+	report := explainReport{
+		Candidates: []explainCandidate{},
+	}
+	if best != nil {
+		report.Matched = true
+		report.EndpointID = best.endpoint.ID
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if best != nil && results[i] == best {
+			return true
+		}
+		if best != nil && results[j] == best {
+			return false
+		}
+		if len(results[i].failed) != len(results[j].failed) {
+			return len(results[i].failed) < len(results[j].failed)
+		}
+		return results[i].score > results[j].score
+	})
+
+	for i, r := range results {
+		if i == maxExplainSize {
+			break
+		}
+		report.Candidates = append(report.Candidates, explainCandidate{
+			ID:       r.endpoint.ID,
+			Method:   r.endpoint.Method,
+			Methods:  r.endpoint.Methods,
+			URL:      r.endpoint.URL,
+			Priority: r.endpoint.Priority,
+			Score:    r.score,
+			Matched:  r.matched(),
+			Failed:   r.failed,
+		})
+	}
+
+	return report
+}
+
+func describeValues(values []string) string {
+	if len(values) == 0 {
+		return "nothing"
+	}
+	return strings.Join(values, ", ")
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedMatcherKeys(m map[string]*valueMatcher) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
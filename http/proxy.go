@@ -0,0 +1,50 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"github.com/foodora/go-ranger/fdhttp"
+	"github.com/guilherme-santos/stubserver"
+)
+
+// upstreamEndpoint is used for requests not matching any endpoint when there's an
+// upstream.
+var upstreamEndpoint = &endpoint{
+	ConfigRequest: stubserver.ConfigRequest{
+		ID:    "upstream",
+		Proxy: true,
+	},
+}
+
+// upstream returns the upstream given to the handler or, when it's nil, the one
+// of the config of m.
+func (h *Handler) upstream(m *matcher) *url.URL {
+	if h.Upstream != nil {
+		return h.Upstream
+	}
+	return m.upstream
+}
+
+// proxy sends req to the upstream and its response to the client.
+func (h *Handler) proxy(w http.ResponseWriter, req *http.Request, endpoint *endpoint) {
+	target := h.upstream(h.matcher())
+	if target == nil {
+		fdhttp.ResponseJSON(w, http.StatusBadGateway, map[string]interface{}{
+			"error":   "no_upstream",
+			"message": "endpoint " + endpoint.ID + " is a proxy but there's no upstream",
+		})
+		return
+	}
+
+	h.DebugLogger.Printf("%s %s: proxy to %s", req.Method, req.URL, target)
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	director := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		director(r)
+		r.Host = target.Host
+	}
+	proxy.ServeHTTP(w, req)
+}
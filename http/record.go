@@ -0,0 +1,263 @@
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/foodora/go-ranger/fdhttp"
+	"github.com/guilherme-santos/stubserver"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	// RecordConfigFile is the name of the config file written by the recorder.
+	RecordConfigFile = "stubserver.yml"
+	// RecordResponsesDir is the directory, inside the config one, of the response
+	// files written by the recorder.
+	RecordResponsesDir = "responses"
+	// DefaultRecordInlineSize is the size of the largest body kept in the config
+	// file, larger ones are written to response files.
+	DefaultRecordInlineSize = 1024
+)
+
+// recordIgnoredHeaders are not recorded in responses, they're set again when served.
+var recordIgnoredHeaders = []string{"Date", "Content-Length"}
+
+// Recorder proxies requests to an upstream and records each unique exchange as an
+// endpoint of a config file, written to Dir every time a new exchange is recorded.
+//
+// Requests are the same when they have the same method, path, query string and
+// headers, only the query string params in Query ("*" for all) and the headers in
+// Headers are compared. When they're the same only the first one is recorded.
+type Recorder struct {
+	Upstream *url.URL
+	Dir      string
+	Query    []string
+	Headers  []string
+	// InlineSize is the size of the largest body kept in the config file, larger
+	// ones and the ones that are not text are written to response files.
+	InlineSize int
+
+	mu        sync.Mutex
+	keys      map[string]bool
+	endpoints []recordedEndpoint
+}
+
+// recordedEndpoint is how an endpoint is written to the config file, only with
+// the fields used by the recorder.
+type recordedEndpoint struct {
+	ID       string           `yaml:"id"`
+	URL      string           `yaml:"url"`
+	Method   string           `yaml:"method"`
+	Headers  http.Header      `yaml:"headers,omitempty"`
+	Response recordedResponse `yaml:"response"`
+}
+
+// recordedResponse is sent as it was recorded, its body is not a template even if
+// it looks like one, e.g. a page with {{ user.name }}.
+type recordedResponse struct {
+	Headers    http.Header `yaml:"headers,omitempty"`
+	StatusCode int         `yaml:"statuscode"`
+	Data       string      `yaml:"data,omitempty"`
+	Template   string      `yaml:"template,omitempty"`
+}
+
+// NewRecorder returns a recorder of the requests sent to upstream, by default all
+// query string params and no header distinguish requests.
+func NewRecorder(upstream *url.URL, dir string) *Recorder {
+	return &Recorder{
+		Upstream:   upstream,
+		Dir:        dir,
+		Query:      []string{"*"},
+		InlineSize: DefaultRecordInlineSize,
+		keys:       map[string]bool{},
+	}
+}
+
+func (r *Recorder) Init(router *fdhttp.Router) {
+	for _, method := range stdMethods {
+		router.StdHandler(method, "/*anything", r.ServeHTTP)
+	}
+}
+
+// ServeHTTP sends req to the upstream and records the response.
+func (r *Recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var body []byte
+	if req.Body != nil {
+		body, _ = ioutil.ReadAll(req.Body)
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	// without Accept-Encoding the response is decompressed by the transport
+	req.Header.Del("Accept-Encoding")
+
+	proxy := httputil.NewSingleHostReverseProxy(r.Upstream)
+	director := proxy.Director
+	proxy.Director = func(outReq *http.Request) {
+		director(outReq)
+		outReq.Host = r.Upstream.Host
+	}
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		respBody, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+		return r.record(req, resp, respBody)
+	}
+	proxy.ServeHTTP(w, req)
+}
+
+func (r *Recorder) record(req *http.Request, resp *http.Response, body []byte) error {
+	e := recordedEndpoint{
+		URL:     r.recordedURL(req.URL),
+		Method:  req.Method,
+		Headers: r.recordedHeaders(req.Header),
+	}
+
+	key := e.Method + " " + e.URL
+	for _, k := range sortedKeys(e.Headers) {
+		key += "\n" + k + ": " + strings.Join(e.Headers[k], ", ")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.keys == nil {
+		r.keys = map[string]bool{}
+	}
+	if r.keys[key] {
+		return nil
+	}
+
+	e.ID = fmt.Sprintf("recorded-%d", len(r.endpoints)+1)
+	e.Response = recordedResponse{
+		Headers:    cloneHeader(resp.Header),
+		StatusCode: resp.StatusCode,
+		Template:   stubserver.TemplateNone,
+	}
+	for _, k := range recordIgnoredHeaders {
+		e.Response.Headers.Del(k)
+	}
+	if len(e.Response.Headers) == 0 {
+		e.Response.Headers = nil
+	}
+
+	// data starting with @ would be read as a file name
+	if len(body) <= r.InlineSize && isText(body) && !bytes.HasPrefix(body, []byte("@")) {
+		e.Response.Data = string(body)
+	} else {
+		filename, err := r.writeResponseFile(e, body)
+		if err != nil {
+			return err
+		}
+		// headers and status code are in the file
+		e.Response = recordedResponse{Data: "@" + filename, Template: stubserver.TemplateNone}
+	}
+
+	if err := r.save(append(r.endpoints, e)); err != nil {
+		return err
+	}
+
+	r.keys[key] = true
+	r.endpoints = append(r.endpoints, e)
+	return nil
+}
+
+// recordedURL returns the path of u with only the query string params in r.Query.
+func (r *Recorder) recordedURL(u *url.URL) string {
+	query := url.Values{}
+	for k, v := range u.Query() {
+		if containsFold(r.Query, "*") || containsFold(r.Query, k) {
+			query[k] = v
+		}
+	}
+
+	recorded := u.EscapedPath()
+	if len(query) > 0 {
+		// Encode sorts by key
+		recorded += "?" + query.Encode()
+	}
+	return recorded
+}
+
+func (r *Recorder) recordedHeaders(header http.Header) http.Header {
+	recorded := http.Header{}
+	for _, k := range r.Headers {
+		k = http.CanonicalHeaderKey(k)
+		if v, ok := header[k]; ok {
+			recorded[k] = append([]string(nil), v...)
+		}
+	}
+	if len(recorded) == 0 {
+		return nil
+	}
+	return recorded
+}
+
+// writeResponseFile writes the response of e in the format read by parseResponse:
+// status line, headers, empty line and body. It returns the name of the file
+// relative to r.Dir.
+func (r *Recorder) writeResponseFile(e recordedEndpoint, body []byte) (string, error) {
+	filename := filepath.Join(RecordResponsesDir, e.ID+".http")
+
+	err := os.MkdirAll(filepath.Join(r.Dir, RecordResponsesDir), 0755)
+	if err != nil {
+		return "", err
+	}
+
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "HTTP/1.1 %d %s\n", e.Response.StatusCode, http.StatusText(e.Response.StatusCode))
+
+	for _, k := range sortedKeys(e.Response.Headers) {
+		for _, v := range e.Response.Headers[k] {
+			fmt.Fprintf(buf, "%s: %s\n", k, v)
+		}
+	}
+	buf.WriteString("\n")
+	buf.Write(body)
+
+	err = ioutil.WriteFile(filepath.Join(r.Dir, filename), buf.Bytes(), 0644)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(filename), nil
+}
+
+// save writes endpoints to the config file, it's written to a temporary file first
+// to not leave a broken config if it fails.
+func (r *Recorder) save(endpoints []recordedEndpoint) error {
+	b, err := yaml.Marshal(map[string]interface{}{
+		"endpoints": endpoints,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(r.Dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	filename := filepath.Join(r.Dir, RecordConfigFile)
+	err = ioutil.WriteFile(filename+".tmp", b, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
+
+// isText reports whether b can be kept in the config file.
+func isText(b []byte) bool {
+	return utf8.Valid(b) && bytes.IndexByte(b, 0) < 0
+}
@@ -0,0 +1,144 @@
+package http_test
+
+import (
+	"io/ioutil"
+	gohttp "net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/guilherme-santos/stubserver"
+	"github.com/guilherme-santos/stubserver/http"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecorder(t *testing.T) {
+	upstream := httptest.NewServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, req *gohttp.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if req.URL.Path == "/large" {
+			w.Write([]byte(strings.Repeat("a", 40)))
+			return
+		}
+		w.WriteHeader(gohttp.StatusCreated)
+		w.Write([]byte(`{"version":"` + req.Header.Get("X-Version") + `","page":"` + req.URL.Query().Get("page") + `"}`))
+	}))
+	defer upstream.Close()
+
+	dir, err := ioutil.TempDir("", "stubserver")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	upstreamURL, _ := url.Parse(upstream.URL)
+	r := http.NewRecorder(upstreamURL, dir)
+	r.Query = []string{"page"}
+	r.Headers = []string{"X-Version"}
+	r.InlineSize = 30
+
+	send := func(method, target string, header gohttp.Header) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send(gohttp.MethodGet, "/users?page=1&ts=1", gohttp.Header{"X-Version": {"1"}})
+	assert.Equal(t, gohttp.StatusCreated, w.Code)
+	assert.Equal(t, `{"version":"1","page":"1"}`, w.Body.String())
+
+	// same request, ts is not compared
+	send(gohttp.MethodGet, "/users?page=1&ts=2", gohttp.Header{"X-Version": {"1"}})
+	send(gohttp.MethodGet, "/users?page=1", gohttp.Header{"X-Version": {"2"}})
+	send(gohttp.MethodGet, "/large", nil)
+
+	cfg, err := stubserver.LoadConfig(filepath.Join(dir, http.RecordConfigFile))
+	assert.NoError(t, err)
+	assert.Len(t, cfg.Endpoints, 3)
+
+	assert.Equal(t, "/users?page=1", cfg.Endpoints[0].URL)
+	assert.Equal(t, gohttp.Header{"X-Version": {"1"}}, cfg.Endpoints[0].Headers)
+	assert.Equal(t, gohttp.StatusCreated, cfg.Endpoints[0].Response.StatusCode)
+	assert.Equal(t, `{"version":"1","page":"1"}`, cfg.Endpoints[0].Response.Data)
+	assert.Equal(t, gohttp.Header{"X-Version": {"2"}}, cfg.Endpoints[1].Headers)
+
+	assert.Equal(t, "@responses/recorded-3.http", cfg.Endpoints[2].Response.Data)
+	b, err := ioutil.ReadFile(filepath.Join(dir, "responses", "recorded-3.http"))
+	assert.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\nContent-Type: application/json\n\n"+strings.Repeat("a", 40), string(b))
+
+	h := http.NewHandler(cfg)
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(gohttp.MethodGet, "/users?page=1", nil)
+	req.Header.Set("X-Version", "2")
+	h.Generic(w, req)
+	assert.Equal(t, gohttp.StatusCreated, w.Code)
+	assert.Equal(t, `{"version":"2","page":"1"}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	h.Generic(w, httptest.NewRequest(gohttp.MethodGet, "/large", nil))
+	assert.Equal(t, gohttp.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Equal(t, strings.Repeat("a", 40), w.Body.String())
+}
+
+func TestRecorder_Replay(t *testing.T) {
+	page := `<p>{{ user.name }}</p>`
+	upstream := httptest.NewServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, req *gohttp.Request) {
+		switch req.URL.Path {
+		case "/page":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(page))
+		case "/large-page":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(strings.Repeat(page, 10)))
+		case "/redirect":
+			w.Header().Set("Location", "/login")
+			w.WriteHeader(gohttp.StatusFound)
+		case "/deleted":
+			w.Header().Set("X-Deleted", "1")
+			w.WriteHeader(gohttp.StatusNoContent)
+		}
+	}))
+	defer upstream.Close()
+
+	dir, err := ioutil.TempDir("", "stubserver")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	upstreamURL, _ := url.Parse(upstream.URL)
+	r := http.NewRecorder(upstreamURL, dir)
+	r.InlineSize = 100
+
+	for _, path := range []string{"/page", "/large-page", "/redirect", "/deleted"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(gohttp.MethodGet, path, nil))
+	}
+
+	cfg, err := stubserver.LoadConfig(filepath.Join(dir, http.RecordConfigFile))
+	assert.NoError(t, err)
+	assert.Equal(t, "@responses/recorded-2.http", cfg.Endpoints[1].Response.Data)
+
+	h := http.NewHandler(stubserver.Config{})
+	assert.NoError(t, h.SetConfig(cfg))
+
+	w := stubRequest(h, gohttp.MethodGet, "/page")
+	assert.Equal(t, gohttp.StatusOK, w.Code)
+	assert.Equal(t, page, w.Body.String())
+
+	w = stubRequest(h, gohttp.MethodGet, "/large-page")
+	assert.Equal(t, gohttp.StatusOK, w.Code)
+	assert.Equal(t, strings.Repeat(page, 10), w.Body.String())
+
+	w = stubRequest(h, gohttp.MethodGet, "/redirect")
+	assert.Equal(t, gohttp.StatusFound, w.Code)
+	assert.Equal(t, "/login", w.Header().Get("Location"))
+
+	w = stubRequest(h, gohttp.MethodGet, "/deleted")
+	assert.Equal(t, gohttp.StatusNoContent, w.Code)
+	assert.Equal(t, "1", w.Header().Get("X-Deleted"))
+}
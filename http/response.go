@@ -0,0 +1,319 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/guilherme-santos/stubserver"
+)
+
+// fileResponse is a response file already parsed, statusCode and header are
+// empty when the file has only the body. contentType is the one detected when
+// the file and the endpoint don't have it.
+type fileResponse struct {
+	statusCode  int
+	header      headerTemplate
+	trailer     http.Header
+	contentType string
+	tmpl        bodyTemplate
+}
+
+// readResponseFile reads and parses a response file of e, errors have the name
+// of the file and the line.
+func (e *endpoint) readResponseFile(filename string) (*fileResponse, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open response file: %s", err)
+	}
+
+	raw, err := parseResponse(b)
+	if err != nil {
+		return nil, fmt.Errorf("response file %s: %s", filename, err)
+	}
+	text := raw.body
+
+	headers := raw.header
+	if len(headers) == 0 {
+		headers = e.Response.Headers
+	}
+
+	// the type is only sent for files with just the body, a replayed response must
+	// not get a Content-Type it didn't have, it's still used to find binary bodies
+	var detected string
+	contentType := headers.Get("Content-Type")
+	hasBody := len(raw.body) > 0 && (raw.statusCode == 0 || bodyAllowedForStatus(raw.statusCode))
+	if contentType == "" && hasBody {
+		contentType = detectContentType(filename, raw.body)
+		if raw.statusCode == 0 {
+			detected = contentType
+		}
+	}
+
+	engine := e.Response.Template
+	if !isTextType(contentType) {
+		// binary bodies, e.g. images or archives, are sent as they are
+		engine = stubserver.TemplateNone
+	}
+
+	if raw.bodyLine > 0 && engine != stubserver.TemplateNone {
+		// the comment keeps the lines in template errors the same as in the file
+		text = append([]byte("{{/*"+strings.Repeat("\n", raw.bodyLine)+"*/}}"), text...)
+	}
+
+	tmpl, err := parseTemplate(filename, string(text), engine, headers, e.funcs)
+	if err != nil {
+		return nil, err
+	}
+
+	headerTmpl, err := parseHeaderTemplate(filename, raw.header, e.funcs)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileResponse{
+		statusCode:  raw.statusCode,
+		header:      headerTmpl,
+		trailer:     raw.trailer,
+		contentType: detected,
+		tmpl:        tmpl,
+	}, nil
+}
+
+// detectContentType returns the content type of a response file by the extension
+// of filename or, when it's unknown, by the first bytes of body.
+func detectContentType(filename string, body []byte) string {
+	if contentType := mime.TypeByExtension(filepath.Ext(filename)); contentType != "" {
+		return contentType
+	}
+	return http.DetectContentType(body)
+}
+
+// rawResponse is a response file split in its parts, statusCode is 0 when the
+// file has only the body. bodyLine is the number of lines before the body.
+type rawResponse struct {
+	statusCode int
+	header     http.Header
+	trailer    http.Header
+	body       []byte
+	bodyLine   int
+}
+
+var statusLine = regexp.MustCompile(`^HTTP/([1-9])(?:\.([0-9]))? +([1-9][0-9][0-9])( .*)?$`)
+
+// parseResponse parses b as an HTTP response, e.g. the output of curl -i, when
+// it doesn't start with a status line b is the body. Lines starting with # before
+// the body are comments, and interim responses (1xx) before the final one are
+// skipped. The body is decoded when it's chunked, otherwise it's the rest of the
+// file. Content-Length is removed, it's sent again computed from the body served
+// since the file can be edited and the body can be a template.
+func parseResponse(b []byte) (*rawResponse, error) {
+	var raw *rawResponse
+
+	for rest, n := b, 0; ; {
+		head, body, lines, err := readHead(rest, n)
+		if err != nil {
+			return nil, err
+		}
+		if head == nil {
+			if raw != nil {
+				// an interim response without the final one
+				return raw, nil
+			}
+			return &rawResponse{body: b}, nil
+		}
+
+		resp, err := http.ReadResponse(bufio.NewReader(io.MultiReader(bytes.NewReader(head), bytes.NewReader(body))), nil)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n+1, err)
+		}
+		n += lines
+
+		raw = &rawResponse{
+			statusCode: resp.StatusCode,
+			header:     resp.Header,
+			bodyLine:   n,
+		}
+		raw.header.Del("Content-Length")
+
+		if resp.StatusCode < 200 && resp.StatusCode != http.StatusSwitchingProtocols {
+			rest = body
+			continue
+		}
+
+		raw.body = body
+		if len(resp.TransferEncoding) > 0 {
+			// curl -i decodes chunked bodies but keeps the Transfer-Encoding header,
+			// they're kept as they are when they're not valid chunks
+			if decoded, err := ioutil.ReadAll(resp.Body); err == nil {
+				raw.body = decoded
+			}
+		}
+
+		for k, v := range resp.Trailer {
+			if len(v) == 0 {
+				continue
+			}
+			if raw.trailer == nil {
+				raw.trailer = http.Header{}
+			}
+			raw.trailer[k] = v
+		}
+		return raw, nil
+	}
+}
+
+// readHead returns the status line and headers at the beginning of b, without
+// comments and with CRLF line endings, and rest after the empty line ending them.
+// head is nil when b doesn't start with a status line. n is the number of lines
+// in the file before b, lines is the number of lines read.
+func readHead(b []byte, n int) (head, rest []byte, lines int, err error) {
+	buf := new(bytes.Buffer)
+
+	for rest = b; len(rest) > 0; {
+		var line string
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			line, rest = string(rest[:i+1]), rest[i+1:]
+		} else {
+			line, rest = string(rest), nil
+		}
+		lines++
+		line = strings.TrimRight(line, "\r\n")
+
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			// it's a comment ignore it
+			continue
+		}
+
+		if buf.Len() == 0 {
+			m := statusLine.FindStringSubmatch(line)
+			if m == nil {
+				return nil, b, 0, nil
+			}
+			// HTTP/2 has no minor version, e.g. in the output of curl -i
+			if m[2] == "" {
+				m[2] = "0"
+			}
+			fmt.Fprintf(buf, "HTTP/%s.%s %s%s\r\n", m[1], m[2], m[3], m[4])
+			continue
+		}
+
+		if line == "" {
+			// empty line means we read all headers
+			buf.WriteString("\r\n")
+			return buf.Bytes(), rest, lines, nil
+		}
+		if line[0] != ' ' && line[0] != '\t' && !strings.Contains(line, ":") {
+			// lines starting with spaces continue the previous header
+			return nil, nil, 0, fmt.Errorf("line %d: header '%s' has no colon", n+lines, strings.TrimSpace(line))
+		}
+		buf.WriteString(line + "\r\n")
+	}
+
+	if buf.Len() == 0 {
+		return nil, b, 0, nil
+	}
+	// headers without body
+	buf.WriteString("\r\n")
+	return buf.Bytes(), nil, lines, nil
+}
+
+// errOutsideDir is returned when the file name of a response is outside of the
+// config directory.
+var errOutsideDir = errors.New("file is outside of the config directory")
+
+// responseFilename returns the file name of the response of e relative to e.dir,
+// when it has templates they're executed with data and the file must be inside e.dir.
+func (e *endpoint) responseFilename(data interface{}) (string, error) {
+	if e.fileTmpl == nil {
+		return e.resolveFilename(e.Response.Data[1:]), nil
+	}
+
+	name, err := executeString(e.fileTmpl, data)
+	if err != nil {
+		return "", err
+	}
+
+	dir := e.dir
+	if dir == "" {
+		dir = "."
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	// Join cleans the name, the ../ in it can go out of dir
+	filename := filepath.Join(dir, filepath.FromSlash(name))
+	if rel, err := filepath.Rel(dir, filename); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errOutsideDir
+	}
+	return filename, nil
+}
+
+// resolveFilename returns name relative to e.dir, or to the working directory when
+// there's no dir.
+func (e *endpoint) resolveFilename(name string) string {
+	if e.dir == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(e.dir, name)
+}
+
+// fileCache keeps the response files of an endpoint already parsed, a file is
+// parsed again when its modification time or size change.
+type fileCache struct {
+	mu    sync.Mutex
+	files map[string]*cachedFile
+}
+
+type cachedFile struct {
+	modTime time.Time
+	size    int64
+	file    *fileResponse
+}
+
+// responseFile returns the response file filename of e parsed, from the cache
+// when it didn't change.
+func (e *endpoint) responseFile(filename string) (*fileResponse, error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open response file: %s", err)
+	}
+
+	e.files.mu.Lock()
+	cached, ok := e.files.files[filename]
+	e.files.mu.Unlock()
+	if ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.file, nil
+	}
+
+	file, err := e.readResponseFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	e.files.mu.Lock()
+	if e.files.files == nil {
+		e.files.files = map[string]*cachedFile{}
+	}
+	e.files.files[filename] = &cachedFile{
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		file:    file,
+	}
+	e.files.mu.Unlock()
+
+	return file, nil
+}
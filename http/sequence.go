@@ -0,0 +1,123 @@
+package http
+
+import (
+	"math/rand"
+	"sync"
+
+	"github.com/guilherme-santos/stubserver"
+)
+
+// counters are how many times the endpoints with many responses were called, by
+// ID. They're kept by the handler, not by the config, to continue the sequences
+// when endpoints are changed using the admin API.
+type counters struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// next returns how many times the endpoint id was called before and counts one
+// more call.
+func (c *counters) next(id string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.counts == nil {
+		c.counts = map[string]int{}
+	}
+	n := c.counts[id]
+	c.counts[id]++
+	return n
+}
+
+// get returns how many times the endpoint id was called, without counting a call.
+func (c *counters) get(id string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.counts[id]
+}
+
+// reset starts again the responses of the endpoint id, or of all endpoints when
+// id is empty.
+func (c *counters) reset(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if id == "" {
+		c.counts = nil
+		return
+	}
+	delete(c.counts, id)
+}
+
+// all returns a copy of the counters.
+func (c *counters) all() map[string]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	counts := make(map[string]int, len(c.counts))
+	for id, n := range c.counts {
+		counts[id] = n
+	}
+	return counts
+}
+
+// nextResponse returns the endpoint with the response of e to send now, it's e
+// itself when it doesn't have Responses.
+func (h *Handler) nextResponse(e *endpoint) *endpoint {
+	if len(e.responses) == 0 {
+		return e
+	}
+
+	return e.responseAt(h.counters.next(e.ID))
+}
+
+// peekResponse returns the response nextResponse would return now without counting
+// the call, e.g. for HEAD requests answered by the GET endpoint, which must not use
+// up its responses.
+func (h *Handler) peekResponse(e *endpoint) *endpoint {
+	if len(e.responses) == 0 {
+		return e
+	}
+
+	return e.responseAt(h.counters.get(e.ID))
+}
+
+// responseAt returns the response of e to send after n calls.
+func (e *endpoint) responseAt(n int) *endpoint {
+	switch e.ResponseMode {
+	case stubserver.ResponseCycle:
+		return e.responses[n%len(e.responses)]
+	case stubserver.ResponseRandom:
+		return randomResponse(e.responses, e.rnd)
+	}
+
+	if n >= len(e.responses) {
+		n = len(e.responses) - 1
+	}
+	return e.responses[n]
+}
+
+// randomResponse returns one of responses chosen by their weight.
+func randomResponse(responses []*endpoint, rnd *rand.Rand) *endpoint {
+	total := 0
+	for _, r := range responses {
+		total += responseWeight(r)
+	}
+
+	n := rnd.Intn(total)
+	for _, r := range responses {
+		n -= responseWeight(r)
+		if n < 0 {
+			return r
+		}
+	}
+	return responses[len(responses)-1]
+}
+
+func responseWeight(e *endpoint) int {
+	if e.Response.Weight <= 0 {
+		return 1
+	}
+	return e.Response.Weight
+}
@@ -0,0 +1,176 @@
+package http_test
+
+import (
+	gohttp "net/http"
+	"testing"
+
+	"github.com/guilherme-santos/stubserver"
+	"github.com/guilherme-santos/stubserver/http"
+	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestGeneric_Responses(t *testing.T) {
+	var cfg stubserver.Config
+	err := yaml.Unmarshal([]byte(`
+seed: 42
+endpoints:
+  - id: sequence
+    url: /sequence/{id}
+    responses:
+      - statuscode: 503
+        data: unavailable {{.Params.id}}
+      - statuscode: 200
+        data: ok {{.Params.id}}
+  - id: cycle
+    url: /cycle
+    responsemode: cycle
+    responses: [a, b, c]
+  - id: random
+    url: /random
+    responsemode: random
+    responses:
+      - data: rare
+      - data: common
+        weight: 99
+`), &cfg)
+	assert.NoError(t, err)
+
+	h := http.NewHandler(cfg)
+
+	send := func(path string) (int, string) {
+		w := stubRequest(h, gohttp.MethodGet, path)
+		return w.Code, w.Body.String()
+	}
+
+	code, body := send("/sequence/1")
+	assert.Equal(t, gohttp.StatusServiceUnavailable, code)
+	assert.Equal(t, "unavailable 1", body)
+	for i := 0; i < 3; i++ {
+		code, body = send("/sequence/1")
+		assert.Equal(t, gohttp.StatusOK, code)
+		assert.Equal(t, "ok 1", body)
+	}
+
+	var cycle []string
+	for i := 0; i < 5; i++ {
+		_, body := send("/cycle")
+		cycle = append(cycle, body)
+	}
+	assert.Equal(t, []string{"a", "b", "c", "a", "b"}, cycle)
+
+	common := 0
+	for i := 0; i < 100; i++ {
+		if _, body := send("/random"); body == "common" {
+			common++
+		}
+	}
+	assert.True(t, common > 90, "common was sent %d times", common)
+}
+
+func TestGeneric_ResponsesHeadFromGet(t *testing.T) {
+	h := http.NewHandler(stubserver.Config{
+		Endpoints: []stubserver.ConfigRequest{
+			{
+				ID:     "users",
+				URL:    "/users",
+				Method: "GET",
+				Responses: []stubserver.ConfigResponse{
+					{StatusCode: gohttp.StatusServiceUnavailable},
+					{StatusCode: gohttp.StatusOK},
+				},
+			},
+		},
+	})
+
+	assert.Equal(t, gohttp.StatusServiceUnavailable, stubRequest(h, gohttp.MethodHead, "/users").Code)
+	assert.Equal(t, gohttp.StatusServiceUnavailable, stubRequest(h, gohttp.MethodGet, "/users").Code)
+	assert.Equal(t, gohttp.StatusOK, stubRequest(h, gohttp.MethodHead, "/users").Code)
+	assert.Equal(t, gohttp.StatusOK, stubRequest(h, gohttp.MethodGet, "/users").Code)
+
+	w := adminRequest(h, gohttp.MethodGet, "/__admin/counters", "")
+	assert.JSONEq(t, `{"counters": {"users": 2}}`, w.Body.String())
+}
+
+func TestAdmin_Counters(t *testing.T) {
+	h := http.NewHandler(stubserver.Config{
+		Endpoints: []stubserver.ConfigRequest{
+			{
+				ID:  "users",
+				URL: "/users",
+				Responses: []stubserver.ConfigResponse{
+					{StatusCode: gohttp.StatusServiceUnavailable},
+					{StatusCode: gohttp.StatusOK},
+				},
+			},
+		},
+	})
+
+	assert.Equal(t, gohttp.StatusServiceUnavailable, stubRequest(h, gohttp.MethodGet, "/users").Code)
+	assert.Equal(t, gohttp.StatusOK, stubRequest(h, gohttp.MethodGet, "/users").Code)
+
+	w := adminRequest(h, gohttp.MethodGet, "/__admin/counters", "")
+	assert.Equal(t, gohttp.StatusOK, w.Code)
+	assert.JSONEq(t, `{"counters": {"users": 2}}`, w.Body.String())
+
+	w = adminRequest(h, gohttp.MethodDelete, "/__admin/counters/users", "")
+	assert.Equal(t, gohttp.StatusNoContent, w.Code)
+	assert.Equal(t, gohttp.StatusServiceUnavailable, stubRequest(h, gohttp.MethodGet, "/users").Code)
+
+	w = adminRequest(h, gohttp.MethodDelete, "/__admin/counters", "")
+	assert.Equal(t, gohttp.StatusNoContent, w.Code)
+	assert.Equal(t, gohttp.StatusServiceUnavailable, stubRequest(h, gohttp.MethodGet, "/users").Code)
+
+	w = adminRequest(h, gohttp.MethodDelete, "/__admin/counters/nope", "")
+	assert.Equal(t, gohttp.StatusNotFound, w.Code)
+
+	// replacing the endpoint starts its responses again
+	stubRequest(h, gohttp.MethodGet, "/users")
+	w = adminRequest(h, gohttp.MethodPut, "/__admin/endpoints/users", `{"url": "/users", "responsemode": "cycle", "responses": [{"statuscode": 503}, {"statuscode": 200}]}`)
+	assert.Equal(t, gohttp.StatusOK, w.Code)
+	assert.Equal(t, gohttp.StatusServiceUnavailable, stubRequest(h, gohttp.MethodGet, "/users").Code)
+}
+
+func TestAdmin_ResponsesRoundTrip(t *testing.T) {
+	h := http.NewHandler(stubserver.Config{
+		Endpoints: []stubserver.ConfigRequest{
+			{
+				ID:           "users",
+				URL:          "/users",
+				ResponseMode: stubserver.ResponseCycle,
+				Responses: []stubserver.ConfigResponse{
+					{StatusCode: gohttp.StatusServiceUnavailable},
+					{StatusCode: gohttp.StatusOK, Data: "[]"},
+				},
+			},
+		},
+	})
+
+	w := adminRequest(h, gohttp.MethodGet, "/__admin/endpoints/users", "")
+	assert.Equal(t, gohttp.StatusOK, w.Code)
+
+	w = adminRequest(h, gohttp.MethodPut, "/__admin/endpoints/users", w.Body.String())
+	assert.Equal(t, gohttp.StatusOK, w.Code, w.Body.String())
+
+	assert.Equal(t, gohttp.StatusServiceUnavailable, stubRequest(h, gohttp.MethodGet, "/users").Code)
+	assert.Equal(t, "[]", stubRequest(h, gohttp.MethodGet, "/users").Body.String())
+}
+
+func TestConfigRequest_UnmarshalYAMLResponses(t *testing.T) {
+	tests := []struct {
+		yaml string
+		err  string
+	}{
+		{"url: /users\nresponse: a\nresponses: [b]", "UnmarshalYAML: response and responses cannot be used together"},
+		{"url: /users\nresponsemode: shuffle\nresponses: [a]", "UnmarshalYAML: responsemode must be sequence, cycle or random"},
+		{"url: /users\nresponses: [{data: a, weight: -1}]", "UnmarshalYAML: weight cannot be negative"},
+	}
+
+	for _, test := range tests {
+		var endpoint stubserver.ConfigRequest
+		err := yaml.Unmarshal([]byte(test.yaml), &endpoint)
+		if assert.Error(t, err, test.yaml) {
+			assert.Equal(t, test.err, err.Error(), test.yaml)
+		}
+	}
+}
@@ -1,169 +1,156 @@
 package http
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"html/template"
-	"io"
 	"io/ioutil"
 	"log"
+	"mime"
 	"net/http"
 	"net/url"
 	"os"
-	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/foodora/go-ranger/fdhttp"
 	"github.com/guilherme-santos/stubserver"
 )
 
 type Handler struct {
-	cfg         stubserver.Config
+	mu sync.RWMutex
+	m  *matcher
+	// fileCfg is the config given to SetConfig, cfg is the same config with the
+	// changes made to its endpoints using the admin API, and stubs are the endpoints
+	// created by the admin API.
+	fileCfg stubserver.Config
+	cfg     stubserver.Config
+	stubs   []stubserver.ConfigRequest
+
+	// Strict makes requests not matching any endpoint receive 404, it can also
+	// be enabled by the config.
+	Strict bool
+	// Upstream takes precedence over the upstream of the config.
+	Upstream *url.URL
+	// Journal keeps the requests received by Generic.
+	Journal     *Journal
 	DebugLogger *log.Logger
+
+	counters counters
 }
 
+// NewHandler returns a handler serving cfg, it panics if cfg is not valid.
+// Use SetConfig to load a config checking for errors.
 func NewHandler(cfg stubserver.Config) *Handler {
-	return &Handler{
-		cfg:         cfg,
+	h := &Handler{
+		m:           &matcher{},
+		Journal:     NewJournal(DefaultJournalSize),
 		DebugLogger: log.New(ioutil.Discard, "[handler] ", log.LstdFlags),
 	}
+	if err := h.SetConfig(cfg); err != nil {
+		panic(err)
+	}
+	return h
 }
 
-func (h *Handler) Init(router *fdhttp.Router) {
-	router.StdGET("/*anything", h.Generic)
-	router.StdPOST("/*anything", h.Generic)
-	router.StdPUT("/*anything", h.Generic)
-	router.StdDELETE("/*anything", h.Generic)
-}
+// SetConfig validates cfg and replaces the config used by the handler, requests
+// already being handled keep using the previous one. Endpoints created using the
+// admin API are kept.
+func (h *Handler) SetConfig(cfg stubserver.Config) error {
+	cfg.Endpoints = withFileIDs(cfg.Endpoints)
 
-func (h *Handler) findEndpoint(method string, reqURL *url.URL, reqHeader http.Header) *stubserver.ConfigRequest {
-	var endpointFound = struct {
-		PassedMethod      bool
-		PassedURL         bool
-		PassedQueryString bool
-		PassedHeaders     bool
-		Endpoint          *stubserver.ConfigRequest
-	}{}
+	h.mu.Lock()
+	defer h.mu.Unlock()
 
-	if len(h.cfg.Endpoints) > 0 {
-		endpointFound.Endpoint = &h.cfg.Endpoints[0]
+	if err := h.apply(cfg, h.stubs); err != nil {
+		return err
 	}
 
-	for k, endpoint := range h.cfg.Endpoints {
-		if !strings.EqualFold(method, endpoint.Method) {
-			h.DebugLogger.Printf("%s %s: doesn't match method '%s' endpoint=%s", method, reqURL, endpoint.Method, endpoint.URL)
-			continue
-		}
-
-		if !endpointFound.PassedMethod {
-			h.DebugLogger.Printf("%s %s: match method '%s' endpoint=%s", method, reqURL, endpoint.Method, endpoint.URL)
-			endpointFound.PassedMethod = true
-			endpointFound.Endpoint = &h.cfg.Endpoints[k]
-		}
-
-		if strings.HasPrefix(endpoint.URL, "~") {
-			endpointURL := strings.TrimSpace(endpoint.URL[1:])
-
-			regex := regexp.MustCompile(endpointURL)
-			if ok := regex.MatchString(reqURL.String()); ok {
-				if !endpointFound.PassedURL {
-					h.DebugLogger.Printf("%s %s: match regex '%s'", method, reqURL, endpoint.URL)
-					endpointFound.PassedURL = true
-					endpointFound.Endpoint = &h.cfg.Endpoints[k]
-				}
-			} else {
-				h.DebugLogger.Printf("%s %s: doesn't match regex %s", method, reqURL, endpointURL)
-			}
-
-			continue
-		} else {
-			endpointURL, _ := url.ParseRequestURI(endpoint.URL)
-			if !strings.EqualFold(reqURL.EscapedPath(), endpointURL.EscapedPath()) {
-				continue
-			}
+	h.fileCfg = cfg
+	h.counters.reset("")
+	return nil
+}
 
-			if !endpointFound.PassedURL {
-				h.DebugLogger.Printf("%s %s: match URL '%s'", method, reqURL, endpoint.URL)
-				endpointFound.PassedURL = true
-				endpointFound.Endpoint = &h.cfg.Endpoints[k]
-			}
+// apply compiles cfg and stubs and starts serving them if they're valid, stubs take
+// precedence over cfg endpoints. h.mu must be held.
+func (h *Handler) apply(cfg stubserver.Config, stubs []stubserver.ConfigRequest) error {
+	all := cfg
+	all.Endpoints = make([]stubserver.ConfigRequest, 0, len(stubs)+len(cfg.Endpoints))
+	all.Endpoints = append(all.Endpoints, stubs...)
+	all.Endpoints = append(all.Endpoints, cfg.Endpoints...)
 
-			match := true
-
-			reqQuery := reqURL.Query()
-			for k, ev := range endpointURL.Query() {
-				if rv, ok := reqQuery[k]; ok {
-					if len(ev) > 0 && ev[0] != "" && !strings.EqualFold(ev[0], rv[0]) {
-						h.DebugLogger.Printf("%s %s: doesn't match query string '%s=%s' endpoint=%s", method, reqURL, k, ev, endpointURL)
-						match = false
-						break
-					}
-				} else {
-					h.DebugLogger.Printf("%s %s: doesn't match query string '%s=%s' endpoint=%s", method, reqURL, k, ev, endpointURL)
-					match = false
-					break
-				}
-			}
+	if err := all.Validate(); err != nil {
+		return err
+	}
 
-			if !match {
-				continue
-			}
+	m, err := newMatcher(all)
+	if err != nil {
+		return err
+	}
 
-			if !endpointFound.PassedQueryString && len(endpointURL.Query()) > 0 {
-				h.DebugLogger.Printf("%s %s: match query string endpoint=%s", method, reqURL, endpointURL)
-				endpointFound.PassedQueryString = true
-				endpointFound.Endpoint = &h.cfg.Endpoints[k]
-			}
-		}
+	h.m = m
+	h.cfg = cfg
+	h.stubs = stubs
+	return nil
+}
 
-		match := true
-
-		for k, ev := range endpoint.Headers {
-			if rv, ok := reqHeader[k]; ok {
-				if len(ev) > 0 && ev[0] != "" && !strings.EqualFold(ev[0], rv[0]) {
-					h.DebugLogger.Printf("%s %s: doesn't match header '%s=%s' endpoint=%s", method, reqURL, k, ev, endpoint.URL)
-					match = false
-					break
-				}
-			} else {
-				h.DebugLogger.Printf("%s %s: doesn't match header '%s=%s' endpoint=%s", method, reqURL, k, ev, endpoint.URL)
-				match = false
-				break
-			}
-		}
+func (h *Handler) matcher() *matcher {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.m
+}
 
-		if !match {
-			continue
-		}
+// stdMethods are routed to the handler, the custom methods reach it through the
+// fallbacks of the router.
+var stdMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodConnect,
+	http.MethodOptions,
+	http.MethodTrace,
+}
 
-		if !endpointFound.PassedHeaders {
-			h.DebugLogger.Printf("%s %s: match header endpoint=%s", method, reqURL, endpoint.URL)
-			endpointFound.PassedHeaders = true
-			endpointFound.Endpoint = &h.cfg.Endpoints[k]
-		}
+// Init routes all standard methods to the handler. Custom methods (e.g. PROPFIND)
+// don't have a route, the router sends them to its fallbacks, which are also the
+// handler, so the ones added later by the admin API or a reload are served too.
+func (h *Handler) Init(router *fdhttp.Router) {
+	for _, method := range stdMethods {
+		router.StdHandler(method, "/*anything", h.ServeHTTP)
 	}
 
-	return endpointFound.Endpoint
+	router.NotFound = http.HandlerFunc(h.ServeHTTP)
+	router.MethodNotAllowed = http.HandlerFunc(h.ServeHTTP)
 }
 
-func templateBody(w http.ResponseWriter, req *http.Request, endpoint *stubserver.ConfigRequest, r io.Reader) io.Reader {
-	if r == nil {
-		return nil
+// ServeHTTP sends requests to the admin API or to the stubs. It cannot be done by
+// the router because it doesn't allow other routes next to a catch-all in the root.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == AdminPath || strings.HasPrefix(req.URL.Path, AdminPath+"/") {
+		h.Admin(w, req)
+		return
 	}
 
-	b, _ := ioutil.ReadAll(r)
-	tmpl := template.Must(template.New("body").Parse(string(b)))
+	h.Generic(w, req)
+}
 
+// templateData returns the data of req available in the templates of endpoint.
+func templateData(req *http.Request, matchReq *request, endpoint *endpoint) map[string]interface{} {
 	data := map[string]interface{}{}
 
-	if strings.HasPrefix(endpoint.URL, "~") {
-		endpointURL := strings.TrimSpace(endpoint.URL[1:])
-		regex := regexp.MustCompile(endpointURL)
-		params := regex.FindStringSubmatch(req.RequestURI)
-		data["RouteParam"] = params[1:]
+	data["Params"] = endpoint.params(req.URL)
+	data["JSONPath"] = endpoint.jsonPathValues(matchReq)
+
+	if endpoint.urlRegex != nil {
+		params := endpoint.urlRegex.FindStringSubmatch(req.URL.String())
+		if len(params) > 0 {
+			data["RouteParam"] = params[1:]
+		}
 	} else {
 		q := req.URL.Query()
 		query := map[string]string{}
@@ -173,113 +160,249 @@ func templateBody(w http.ResponseWriter, req *http.Request, endpoint *stubserver
 		data["Query"] = query
 	}
 
-	req.ParseForm()
 	data["Request"] = req
-
-	if req.Body != nil && req.Header.Get("Content-Type") == "application/json" {
-		if body, err := ioutil.ReadAll(req.Body); err == nil {
-			var j map[string]interface{}
-			if err := json.Unmarshal(body, &j); err == nil {
-				data["JSON"] = j
-			}
+	data["Body"] = string(matchReq.body)
+	data["Headers"] = firstValues(req.Header)
+	data["Path"] = pathSegments(req.URL.Path)
+
+	cookies := map[string]string{}
+	for _, c := range req.Cookies() {
+		if _, ok := cookies[c.Name]; !ok {
+			cookies[c.Name] = c.Value
 		}
 	}
+	data["Cookies"] = cookies
 
-	buf := new(bytes.Buffer)
-	tmpl.Execute(buf, data)
-	return buf
-}
-
-func extractHeader(r io.Reader) (int, http.Header, io.Reader) {
-	buf := new(bytes.Buffer)
-	tee := io.TeeReader(r, buf)
-
-	var statusCode int
-	header := http.Header{}
-
-	validHTTPProtocol := regexp.MustCompile(`^HTTP/[1-9](.[1-9])? ([245][0-9][0-9])`)
-
-	body := bufio.NewReader(tee)
-	for {
-		line, _ := body.ReadString('\n')
-		if line == "" || line == "\n" {
-			// empty line means we read all headers
-			break
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	switch {
+	case isJSON(mediaType):
+		if doc, ok := matchReq.json(); ok {
+			data["JSON"] = doc
 		}
-
-		if strings.HasPrefix(strings.TrimSpace(line), "#") {
-			// it's a comment ignore it
-			continue
+	case isXML(mediaType):
+		if doc, err := decodeXML(matchReq.body); err == nil {
+			data["XML"] = doc
 		}
+	}
 
-		if statusCode == 0 {
-			if validHTTPProtocol.MatchString(line) {
-				statusCode, _ = strconv.Atoi(validHTTPProtocol.FindStringSubmatch(line)[2])
+	req.ParseForm()
+	form := firstValues(req.PostForm)
+	if mediaType == "multipart/form-data" {
+		if err := req.ParseMultipartForm(maxMultipartMemory); err == nil {
+			for k, v := range firstValues(req.MultipartForm.Value) {
+				form[k] = v
 			}
-		} else {
-			parts := strings.SplitN(line, ":", 2)
-			header.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+			data["Files"] = formFiles(req.MultipartForm)
 		}
 	}
+	data["Form"] = form
 
-	if statusCode == 0 {
-		// No headers available let's return the original content
-		return 0, nil, buf
-	}
-
-	return statusCode, header, body
+	return data
 }
 
 func (h *Handler) Generic(w http.ResponseWriter, req *http.Request) {
-	endpoint := h.findEndpoint(req.Method, req.URL, req.Header)
+	entry := JournalEntry{
+		Time:    time.Now(),
+		Method:  req.Method,
+		URL:     req.URL.String(),
+		Headers: cloneHeader(req.Header),
+	}
+	var body []byte
+	if req.Body != nil {
+		body, _ = ioutil.ReadAll(req.Body)
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		entry.Body = string(body)
+	}
+
+	recorder := &statusRecorder{ResponseWriter: w}
+	w = recorder
+	defer func() {
+		entry.StatusCode = recorder.statusCode
+		h.Journal.Add(entry)
+	}()
+
+	matchReq := newRequest(req.Method, req.URL, req.Header, body)
+
+	endpoint, matched, results := h.findEndpoint(matchReq)
+	entry.Matched = matched
+
+	if req.Header.Get(ExplainHeader) != "" {
+		b, _ := json.Marshal(newExplainReport(results))
+		w.Header().Set(ExplainHeader, string(b))
+	}
+
 	if endpoint == nil {
 		fdhttp.ResponseJSON(w, http.StatusNotFound, map[string]interface{}{
-			"error":   "not_found",
-			"message": "didn't match with any endpoint",
+			"error":       "not_found",
+			"message":     "didn't match with any endpoint",
+			"near_misses": newExplainReport(results).Candidates,
 		})
 		return
 	}
+	entry.EndpointID = endpoint.ID
 
-	var body io.Reader
+	if endpoint.Proxy {
+		h.proxy(w, req, endpoint)
+		return
+	}
 
-	if endpoint.Response.Data != "" {
-		if strings.HasPrefix(endpoint.Response.Data, "@") {
-			// it's a file
-			f, err := os.Open(endpoint.Response.Data[1:])
-			if err != nil {
-				fdhttp.ResponseJSON(w, http.StatusInternalServerError, map[string]interface{}{
-					"error":   "invalid_response",
-					"message": fmt.Sprintf("cannot open response file: %s", err),
-				})
+	// the data comes from the endpoint matched, e.g. its path parameters
+	data := templateData(req, matchReq, endpoint)
+	if req.Method == http.MethodHead && !endpoint.matchMethod(http.MethodHead) {
+		h.respond(w, req, h.peekResponse(endpoint), data)
+		return
+	}
+	h.respond(w, req, h.nextResponse(endpoint), data)
+}
+
+// respond sends the response of endpoint to req, data is used by its templates.
+func (h *Handler) respond(w http.ResponseWriter, req *http.Request, endpoint *endpoint, data map[string]interface{}) {
+	// endpoint is shared with other requests, status code and headers coming
+	// from a file must not be written back to it.
+	statusCode := endpoint.Response.StatusCode
+	statusTmpl := endpoint.statusTmpl
+	headerTmpl := endpoint.headerTmpl
+	tmpl := endpoint.tmpl
+	var trailer http.Header
+	var contentType string
+	var replay bool
+
+	if strings.HasPrefix(endpoint.Response.Data, "@") {
+		// it's a file
+		filename, err := endpoint.responseFilename(data)
+		if err == errOutsideDir {
+			fdhttp.ResponseJSON(w, http.StatusForbidden, map[string]interface{}{
+				"error":   "forbidden",
+				"message": fmt.Sprintf("endpoint %s: %s", endpoint.ID, err),
+			})
+			return
+		}
+		if err != nil {
+			endpointError(w, endpoint, "template_error", err)
+			return
+		}
+
+		if _, err := os.Stat(filename); os.IsNotExist(err) && endpoint.fileTmpl != nil {
+			if endpoint.fileNotFound != nil {
+				h.respond(w, req, endpoint.fileNotFound, data)
 				return
 			}
+			fdhttp.ResponseJSON(w, http.StatusNotFound, map[string]interface{}{
+				"error":   "file_not_found",
+				"message": fmt.Sprintf("endpoint %s: response file %s doesn't exist", endpoint.ID, filename),
+			})
+			return
+		}
+
+		file, err := endpoint.responseFile(filename)
+		if err != nil {
+			endpointError(w, endpoint, "invalid_response", err)
+			return
+		}
 
-			var (
-				statusCode int
-				header     http.Header
-			)
+		if file.statusCode != 0 {
+			statusCode = file.statusCode
+			statusTmpl = nil
+			replay = true
+		}
+		if len(file.header) > 0 {
+			headerTmpl = file.header
+		}
+		tmpl = file.tmpl
+		trailer = file.trailer
+		contentType = file.contentType
+	}
 
-			statusCode, header, body = extractHeader(f)
-			if statusCode != 0 {
-				endpoint.Response.StatusCode = statusCode
-			}
-			if len(header) > 0 {
-				endpoint.Response.Headers = header
-			}
-		} else {
-			body = bytes.NewBufferString(endpoint.Response.Data)
+	headers, err := headerTmpl.execute(data)
+	if err != nil {
+		endpointError(w, endpoint, "template_error", err)
+		return
+	}
+
+	if statusTmpl != nil {
+		statusCode, err = executeStatusCode(statusTmpl, data)
+		if err != nil {
+			endpointError(w, endpoint, "template_error", err)
+			return
 		}
+	}
 
-		for k, values := range endpoint.Response.Headers {
-			for _, v := range values {
-				w.Header().Add(k, v)
-			}
+	// the body is ready before sending anything to be able to send an error, it's
+	// also executed for HEAD to send its Content-Length
+	respBody := new(bytes.Buffer)
+	if tmpl != nil {
+		if err := tmpl.Execute(respBody, data); err != nil {
+			endpointError(w, endpoint, "template_error", err)
+			return
 		}
 	}
 
-	w.WriteHeader(endpoint.Response.StatusCode)
-	if body != nil {
-		body = templateBody(w, req, endpoint, body)
-		io.Copy(w, body)
+	for k, values := range headers {
+		for _, v := range values {
+			w.Header().Add(k, v)
+		}
+	}
+	if contentType != "" && w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	if _, ok := w.Header()["Content-Type"]; replay && !ok {
+		// without it net/http detects one
+		w.Header()["Content-Type"] = nil
+	}
+
+	// trailers are declared before the headers are sent and set after the body
+	for _, k := range sortedKeys(trailer) {
+		w.Header().Add("Trailer", k)
+	}
+
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+	if trailer == nil && bodyAllowedForStatus(statusCode) && w.Header().Get("Content-Length") == "" {
+		w.Header().Set("Content-Length", strconv.Itoa(respBody.Len()))
+	}
+
+	if !sleep(req.Context(), delayDuration(endpoint.delay, endpoint.rnd)) {
+		return
+	}
+
+	if fireFault(endpoint.fault, endpoint.rnd) {
+		err := fault(w, endpoint.fault, statusCode, respBody.Bytes(), endpoint.rnd)
+		if err == errHijackNotSupported {
+			endpointError(w, endpoint, "fault_error", fmt.Errorf("fault %s: %s", endpoint.fault.Type, err))
+		} else if err != nil {
+			h.DebugLogger.Printf("%s %s: fault %s: %s", req.Method, req.URL, endpoint.fault.Type, err)
+		}
+		return
+	}
+
+	w.WriteHeader(statusCode)
+	if req.Method != http.MethodHead {
+		writeBody(req.Context(), w, respBody.Bytes(), endpoint.delay, endpoint.rnd)
+	}
+
+	for k, v := range trailer {
+		w.Header()[k] = v
+	}
+}
+
+// bodyAllowedForStatus reports whether a response with statusCode can have a body.
+func bodyAllowedForStatus(statusCode int) bool {
+	return statusCode >= 200 && statusCode != http.StatusNoContent && statusCode != http.StatusNotModified
+}
+
+// endpointError sends a 500 when the response of endpoint cannot be sent.
+func endpointError(w http.ResponseWriter, endpoint *endpoint, code string, err error) {
+	fdhttp.ResponseJSON(w, http.StatusInternalServerError, map[string]interface{}{
+		"error":   code,
+		"message": fmt.Sprintf("endpoint %s: %s", endpoint.ID, err),
+	})
+}
+
+func cloneHeader(header http.Header) http.Header {
+	clone := make(http.Header, len(header))
+	for k, v := range header {
+		clone[k] = append([]string(nil), v...)
 	}
+	return clone
 }
@@ -2,16 +2,25 @@ package http_test
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
 	gohttp "net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 
+	"github.com/foodora/go-ranger/fdhttp"
 	"github.com/guilherme-santos/stubserver"
 	"github.com/guilherme-santos/stubserver/http"
 	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v2"
 )
 
 func TestGeneric_NoEndpoint(t *testing.T) {
@@ -48,6 +57,59 @@ func TestGeneric_SendStatusCode(t *testing.T) {
 	assert.Equal(t, gohttp.StatusCreated, w.Code)
 }
 
+func TestGeneric_SendHeadersWithoutData(t *testing.T) {
+	cfg := stubserver.Config{
+		Endpoints: []stubserver.ConfigRequest{
+			{
+				URL:    "~^/.*$",
+				Method: gohttp.MethodOptions,
+				Response: stubserver.ConfigResponse{
+					Headers: gohttp.Header{
+						"Access-Control-Allow-Origin":  []string{"*"},
+						"Access-Control-Allow-Methods": []string{"GET, POST"},
+					},
+					StatusCode: gohttp.StatusNoContent,
+				},
+			},
+		},
+	}
+
+	h := http.NewHandler(cfg)
+
+	w := httptest.NewRecorder()
+	h.Generic(w, httptest.NewRequest(gohttp.MethodOptions, "/users", nil))
+
+	assert.Equal(t, gohttp.StatusNoContent, w.Code)
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET, POST", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Empty(t, w.Header().Get("Content-Length"))
+	assert.Empty(t, w.Body.String())
+}
+
+func TestInit_CustomMethodAddedLater(t *testing.T) {
+	h := http.NewHandler(stubserver.Config{
+		Endpoints: []stubserver.ConfigRequest{
+			{URL: "/users", Method: "GET", Response: stubserver.ConfigResponse{Data: "[]"}},
+		},
+	})
+
+	router := fdhttp.NewRouter()
+	router.Register(h)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(gohttp.MethodPost, http.AdminPath+"/endpoints", strings.NewReader(`{"url": "/files", "method": "PROPFIND", "response": {"statuscode": 207, "data": "<multistatus/>"}}`)))
+	assert.Equal(t, gohttp.StatusCreated, w.Code, w.Body.String())
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("PROPFIND", "/files", nil))
+	assert.Equal(t, gohttp.StatusMultiStatus, w.Code)
+	assert.Equal(t, "<multistatus/>", w.Body.String())
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(gohttp.MethodGet, "/users", nil))
+	assert.Equal(t, "[]", w.Body.String())
+}
+
 func TestGeneric_SendData(t *testing.T) {
 	cfg := stubserver.Config{
 		Endpoints: []stubserver.ConfigRequest{
@@ -164,7 +226,9 @@ func TestGeneric_SendDataAsFile(t *testing.T) {
 
 	h.Generic(w, req)
 	assert.Equal(t, gohttp.StatusCreated, w.Code)
-	assert.Len(t, w.HeaderMap, 0)
+	assert.Len(t, w.HeaderMap, 2)
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "123", w.Header().Get("Content-Length"))
 	assert.Equal(t, `<!DOCTYPE html>
 <html>
     <body>
@@ -246,3 +310,956 @@ func TestGeneric_SendDataAsFileWithTemplate(t *testing.T) {
 </html>
 `, w.Body.String())
 }
+
+func TestSetConfig(t *testing.T) {
+	cfg := stubserver.Config{
+		Endpoints: []stubserver.ConfigRequest{
+			{Response: stubserver.ConfigResponse{Data: "v1", StatusCode: gohttp.StatusOK}},
+		},
+	}
+
+	h := http.NewHandler(cfg)
+
+	err := h.SetConfig(stubserver.Config{
+		Endpoints: []stubserver.ConfigRequest{
+			{Response: stubserver.ConfigResponse{Data: "v2", StatusCode: gohttp.StatusOK}},
+		},
+	})
+	assert.NoError(t, err)
+
+	err = h.SetConfig(stubserver.Config{
+		Endpoints: []stubserver.ConfigRequest{
+			{URL: "~/users/([0-9]", Response: stubserver.ConfigResponse{Data: "v3", StatusCode: gohttp.StatusOK}},
+		},
+	})
+	assert.Error(t, err)
+
+	w := httptest.NewRecorder()
+	req, err := gohttp.NewRequest(gohttp.MethodGet, "http://stubserver:8080/test", nil)
+	assert.NoError(t, err)
+
+	h.Generic(w, req)
+	assert.Equal(t, "v2", w.Body.String())
+}
+
+func TestSetConfig_SameIDsAfterReload(t *testing.T) {
+	cfg := stubserver.Config{
+		Endpoints: []stubserver.ConfigRequest{
+			{URL: "/users", Method: "GET"},
+			{URL: "/users", Method: "POST"},
+			{URL: "/users", Method: "GET"},
+		},
+	}
+
+	ids := func(h *http.Handler) []string {
+		var body struct {
+			Endpoints []stubserver.ConfigRequest `json:"endpoints"`
+		}
+		w := adminRequest(h, gohttp.MethodGet, "/__admin/endpoints", "")
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+
+		var ids []string
+		for _, endpoint := range body.Endpoints {
+			assert.NotEmpty(t, endpoint.ID)
+			ids = append(ids, endpoint.ID)
+		}
+		return ids
+	}
+
+	h := http.NewHandler(cfg)
+	before := ids(h)
+	assert.Len(t, before, 3)
+	assert.NotEqual(t, before[0], before[2])
+
+	assert.NoError(t, h.SetConfig(cfg))
+	assert.Equal(t, before, ids(h))
+	assert.Equal(t, before, ids(http.NewHandler(cfg)))
+
+	w := adminRequest(h, gohttp.MethodDelete, "/__admin/endpoints/"+before[1], "")
+	assert.Equal(t, gohttp.StatusNoContent, w.Code)
+}
+
+func TestGeneric_ConcurrentRequests(t *testing.T) {
+	cfg := stubserver.Config{
+		Endpoints: []stubserver.ConfigRequest{
+			{
+				URL:    "/file",
+				Method: "GET",
+				Response: stubserver.ConfigResponse{
+					Data: "@" + filepath.Join("testdata", "html_with_template.golden"),
+				},
+			},
+			{
+				URL:    "~/users/([0-9]+)",
+				Method: "GET",
+				Response: stubserver.ConfigResponse{
+					Headers:    gohttp.Header{"X-User": []string{"inline"}},
+					Data:       `{{index .RouteParam 0}}`,
+					StatusCode: gohttp.StatusOK,
+				},
+			},
+		},
+	}
+
+	h := http.NewHandler(cfg)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 20; j++ {
+				w := httptest.NewRecorder()
+				req := httptest.NewRequest(gohttp.MethodGet, "/file", nil)
+				h.Generic(w, req)
+				assert.Equal(t, gohttp.StatusCreated, w.Code)
+				assert.Empty(t, w.Header().Get("X-User"))
+
+				w = httptest.NewRecorder()
+				req = httptest.NewRequest(gohttp.MethodGet, fmt.Sprintf("/users/%d", i), nil)
+				h.Generic(w, req)
+				assert.Equal(t, gohttp.StatusOK, w.Code)
+				assert.Equal(t, "inline", w.Header().Get("X-User"))
+				assert.Empty(t, w.Header().Get("Server"))
+				assert.Equal(t, strconv.Itoa(i), w.Body.String())
+			}
+		}(i)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for j := 0; j < 20; j++ {
+			assert.NoError(t, h.SetConfig(cfg))
+		}
+	}()
+
+	wg.Wait()
+}
+
+func TestGeneric_SendDataTemplateWithParams(t *testing.T) {
+	cfg := stubserver.Config{
+		Endpoints: []stubserver.ConfigRequest{
+			{
+				URL:    "/users/{id:int}/orders/{orderId}",
+				Method: "GET",
+				Response: stubserver.ConfigResponse{
+					Data:       `{{.Params.id}} {{.Params.orderId}} {{.Query.expand}}`,
+					StatusCode: gohttp.StatusOK,
+				},
+			},
+		},
+	}
+
+	h := http.NewHandler(cfg)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(gohttp.MethodGet, "/users/1/orders/a2?expand=items", nil)
+
+	h.Generic(w, req)
+	assert.Equal(t, "1 a2 items", w.Body.String())
+}
+
+func TestGeneric_SendDataTemplateWithJSONPath(t *testing.T) {
+	cfg := stubserver.Config{
+		Endpoints: []stubserver.ConfigRequest{
+			{
+				URL:    "/payments",
+				Method: "POST",
+				Body: &stubserver.ConfigBody{
+					JSONPath: []stubserver.ConfigJSONPath{
+						{Name: "currency", Path: "$.currency", Regex: "^(EUR|USD)$"},
+						{Path: "$.items[*].sku"},
+					},
+				},
+				Response: stubserver.ConfigResponse{
+					Data:       `{{.JSONPath.currency}} {{index .JSONPath "$.items[*].sku"}}`,
+					StatusCode: gohttp.StatusCreated,
+				},
+			},
+		},
+	}
+
+	h := http.NewHandler(cfg)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(gohttp.MethodPost, "/payments", strings.NewReader(`{"currency": "EUR", "items": [{"sku": "a"}, {"sku": "b"}]}`))
+
+	h.Generic(w, req)
+	assert.Equal(t, gohttp.StatusCreated, w.Code)
+	assert.Equal(t, "EUR [a b]", w.Body.String())
+}
+
+func TestGeneric_ExplainHeader(t *testing.T) {
+	cfg := stubserver.Config{
+		Endpoints: []stubserver.ConfigRequest{
+			{ID: "list", URL: "/users", Method: "GET", Response: stubserver.ConfigResponse{StatusCode: gohttp.StatusOK}},
+		},
+	}
+
+	h := http.NewHandler(cfg)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(gohttp.MethodGet, "/companies", nil)
+	req.Header.Set(http.ExplainHeader, "1")
+
+	h.Generic(w, req)
+	assert.JSONEq(t, `{"matched": false, "candidates": [{"id": "list", "method": "GET", "url": "/users", "priority": 0, "score": 1, "matched": false, "failed": ["path: /companies doesn't match /users"]}]}`, w.Header().Get(http.ExplainHeader))
+
+	w = adminRequest(h, gohttp.MethodPost, "/__admin/explain", `{"method": "GET", "url": "/users"}`)
+	assert.Equal(t, gohttp.StatusOK, w.Code)
+	assert.JSONEq(t, `{"matched": true, "endpoint_id": "list", "candidates": [{"id": "list", "method": "GET", "url": "/users", "priority": 0, "score": 5, "matched": true}]}`, w.Body.String())
+}
+
+func TestGeneric_Strict(t *testing.T) {
+	cfg := stubserver.Config{
+		Strict: true,
+		Endpoints: []stubserver.ConfigRequest{
+			{ID: "list", URL: "/users", Method: "GET", Response: stubserver.ConfigResponse{StatusCode: gohttp.StatusOK}},
+		},
+	}
+
+	h := http.NewHandler(cfg)
+	h.Journal = http.NewJournal(10)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(gohttp.MethodGet, "/companies", nil)
+
+	h.Generic(w, req)
+	assert.Equal(t, gohttp.StatusNotFound, w.Code)
+	assert.JSONEq(t, `{"error": "not_found", "message": "didn't match with any endpoint", "near_misses": [{"id": "list", "method": "GET", "url": "/users", "priority": 0, "score": 1, "matched": false, "failed": ["path: /companies doesn't match /users"]}]}`, w.Body.String())
+
+	entries := h.Journal.Entries()
+	assert.Len(t, entries, 1)
+	assert.False(t, entries[0].Matched)
+	assert.Empty(t, entries[0].EndpointID)
+
+	// same config without strict serves the first endpoint
+	h = http.NewHandler(stubserver.Config{Endpoints: cfg.Endpoints})
+
+	w = httptest.NewRecorder()
+	h.Generic(w, req)
+	assert.Equal(t, gohttp.StatusOK, w.Code)
+
+	h.Strict = true
+
+	w = httptest.NewRecorder()
+	h.Generic(w, req)
+	assert.Equal(t, gohttp.StatusNotFound, w.Code)
+}
+
+func TestGeneric_Fallback(t *testing.T) {
+	cfg := stubserver.Config{
+		Strict: true,
+		Fallback: &stubserver.ConfigResponse{
+			StatusCode: gohttp.StatusTeapot,
+			Data:       `{"error": "not stubbed"}`,
+		},
+		Endpoints: []stubserver.ConfigRequest{
+			{ID: "list", URL: "/users", Method: "GET", Response: stubserver.ConfigResponse{StatusCode: gohttp.StatusOK}},
+		},
+	}
+
+	h := http.NewHandler(cfg)
+	h.Journal = http.NewJournal(10)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(gohttp.MethodGet, "/companies", nil)
+
+	h.Generic(w, req)
+	assert.Equal(t, gohttp.StatusTeapot, w.Code)
+	assert.Equal(t, `{"error": "not stubbed"}`, w.Body.String())
+
+	entries := h.Journal.Entries()
+	assert.Len(t, entries, 1)
+	assert.False(t, entries[0].Matched)
+	assert.Equal(t, "fallback", entries[0].EndpointID)
+}
+
+func TestGeneric_HeadFromGetEndpoint(t *testing.T) {
+	cfg := stubserver.Config{
+		Endpoints: []stubserver.ConfigRequest{
+			{
+				URL:    "/users",
+				Method: "GET",
+				Response: stubserver.ConfigResponse{
+					Headers:    gohttp.Header{"Content-Type": []string{"application/json"}},
+					StatusCode: gohttp.StatusOK,
+					Data:       `[{"id": 1}]`,
+				},
+			},
+		},
+	}
+
+	h := http.NewHandler(cfg)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(gohttp.MethodHead, "/users", nil)
+
+	h.Generic(w, req)
+	assert.Equal(t, gohttp.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Empty(t, w.Body.String())
+}
+
+func TestGeneric_Upstream(t *testing.T) {
+	upstream := httptest.NewServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, req *gohttp.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		w.Header().Set("X-Upstream", "1")
+		w.WriteHeader(gohttp.StatusAccepted)
+		fmt.Fprintf(w, "%s %s %s", req.Method, req.URL, body)
+	}))
+	defer upstream.Close()
+
+	cfg := stubserver.Config{
+		Upstream: upstream.URL,
+		Endpoints: []stubserver.ConfigRequest{
+			{ID: "list", URL: "/users", Method: "GET", Response: stubserver.ConfigResponse{StatusCode: gohttp.StatusOK, Data: "stub"}},
+			{ID: "create", URL: "/users", Method: "POST", Proxy: true},
+		},
+	}
+
+	h := http.NewHandler(cfg)
+	h.Journal = http.NewJournal(10)
+
+	w := httptest.NewRecorder()
+	h.Generic(w, httptest.NewRequest(gohttp.MethodGet, "/users", nil))
+	assert.Equal(t, gohttp.StatusOK, w.Code)
+	assert.Equal(t, "stub", w.Body.String())
+
+	w = httptest.NewRecorder()
+	h.Generic(w, httptest.NewRequest(gohttp.MethodPost, "/users", strings.NewReader("name=Wilhelm")))
+	assert.Equal(t, gohttp.StatusAccepted, w.Code)
+	assert.Equal(t, "1", w.Header().Get("X-Upstream"))
+	assert.Equal(t, "POST /users name=Wilhelm", w.Body.String())
+
+	w = httptest.NewRecorder()
+	h.Generic(w, httptest.NewRequest(gohttp.MethodGet, "/companies?id=1", nil))
+	assert.Equal(t, gohttp.StatusAccepted, w.Code)
+	assert.Equal(t, "GET /companies?id=1 ", w.Body.String())
+
+	entries := h.Journal.Entries()
+	assert.Len(t, entries, 3)
+	assert.Equal(t, "create", entries[1].EndpointID)
+	assert.True(t, entries[1].Matched)
+	assert.Equal(t, gohttp.StatusAccepted, entries[1].StatusCode)
+	assert.Equal(t, "upstream", entries[2].EndpointID)
+	assert.False(t, entries[2].Matched)
+}
+
+func TestGeneric_ProxyWithoutUpstream(t *testing.T) {
+	cfg := stubserver.Config{
+		Endpoints: []stubserver.ConfigRequest{
+			{ID: "create", URL: "/users", Method: "POST", Proxy: true},
+		},
+	}
+
+	h := http.NewHandler(cfg)
+
+	w := httptest.NewRecorder()
+	h.Generic(w, httptest.NewRequest(gohttp.MethodPost, "/users", nil))
+	assert.Equal(t, gohttp.StatusBadGateway, w.Code)
+	assert.JSONEq(t, `{"error": "no_upstream", "message": "endpoint create is a proxy but there's no upstream"}`, w.Body.String())
+}
+
+func TestGeneric_TemplateEngine(t *testing.T) {
+	cfg := stubserver.Config{
+		Endpoints: []stubserver.ConfigRequest{
+			{
+				URL: "/json",
+				Response: stubserver.ConfigResponse{
+					Headers:    gohttp.Header{"Content-Type": []string{"application/json; charset=utf-8"}},
+					StatusCode: gohttp.StatusOK,
+					Data:       `{"name":"{{.Query.name}}","escaped":"{{jsonEscape .Query.name}}"}`,
+				},
+			},
+			{
+				URL: "/html",
+				Response: stubserver.ConfigResponse{
+					Headers:    gohttp.Header{"Content-Type": []string{"text/html"}},
+					StatusCode: gohttp.StatusOK,
+					Data:       `<p>{{.Query.name}}</p>`,
+				},
+			},
+			{
+				URL: "/xml",
+				Response: stubserver.ConfigResponse{
+					Headers:    gohttp.Header{"Content-Type": []string{"application/xml"}},
+					StatusCode: gohttp.StatusOK,
+					Data:       `<user name="{{xmlEscape .Query.name}}" next="/users?name={{urlEscape .Query.name}}"/>`,
+				},
+			},
+			{
+				URL: "/none",
+				Response: stubserver.ConfigResponse{
+					StatusCode: gohttp.StatusOK,
+					Data:       `{{.Query.name}}`,
+					Template:   stubserver.TemplateNone,
+				},
+			},
+		},
+	}
+
+	h := http.NewHandler(cfg)
+	name := url.QueryEscape(`Tom & "Jerry" <3`)
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/json", `{"name":"Tom & "Jerry" <3","escaped":"Tom & \"Jerry\" <3"}`},
+		{"/html", `<p>Tom &amp; &#34;Jerry&#34; &lt;3</p>`},
+		{"/xml", `<user name="Tom &amp; &#34;Jerry&#34; &lt;3" next="/users?name=Tom+%26+%22Jerry%22+%3C3"/>`},
+		{"/none", `{{.Query.name}}`},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		h.Generic(w, httptest.NewRequest(gohttp.MethodGet, test.path+"?name="+name, nil))
+		assert.Equal(t, test.expected, w.Body.String(), test.path)
+	}
+}
+
+func TestGeneric_TemplateFuncs(t *testing.T) {
+	cfg := stubserver.Config{
+		Endpoints: []stubserver.ConfigRequest{
+			{
+				URL:    "/users/{id}",
+				Method: "POST",
+				Response: stubserver.ConfigResponse{
+					StatusCode: gohttp.StatusOK,
+					Data:       `{{add .Params.id 1}} {{div 7 2}} {{mod 7 2}} {{.Query.page | default 1}} {{toJson .JSON.user}} {{base64Encode "stub"}} {{hexEncode "stub"}} {{upper .JSON.user.name}} {{join "," (split "-" "a-b")}}`,
+				},
+			},
+		},
+	}
+
+	h := http.NewHandler(cfg)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(gohttp.MethodPost, "/users/41", strings.NewReader(`{"user": {"name": "Wilhelm & co"}}`))
+	req.Header.Set("Content-Type", "application/json")
+	h.Generic(w, req)
+	assert.Equal(t, `42 3.5 1 1 {"name":"Wilhelm & co"} c3R1Yg== 73747562 WILHELM & CO a,b`, w.Body.String())
+}
+
+func TestGeneric_TemplateFuncsLargeNumbers(t *testing.T) {
+	h := http.NewHandler(stubserver.Config{
+		Endpoints: []stubserver.ConfigRequest{
+			{
+				URL: "/users/{id}",
+				Response: stubserver.ConfigResponse{
+					Data: `{"next": {{add .Params.id 1}}, "x": {{mul .Query.x 1000}}, "half": {{div .Query.x 2}}, "total": {{toJson (add 0.5 (sub .Query.x 1))}}}`,
+				},
+			},
+		},
+	})
+
+	w := stubRequest(h, gohttp.MethodGet, "/users/999999?x=12345")
+	assert.Equal(t, `{"next": 1000000, "x": 12345000, "half": 6172.5, "total": 12344.5}`, w.Body.String())
+}
+
+func TestGeneric_TemplateFuncsSeed(t *testing.T) {
+	cfg := stubserver.Config{
+		Seed: 42,
+		Endpoints: []stubserver.ConfigRequest{
+			{
+				Response: stubserver.ConfigResponse{
+					StatusCode: gohttp.StatusOK,
+					Data:       `{{uuid}} {{randomInt 1 6}} {{randomString 8}}`,
+				},
+			},
+		},
+	}
+
+	send := func(h *http.Handler) string {
+		w := httptest.NewRecorder()
+		h.Generic(w, httptest.NewRequest(gohttp.MethodGet, "/", nil))
+		return w.Body.String()
+	}
+
+	h1 := http.NewHandler(cfg)
+	h2 := http.NewHandler(cfg)
+
+	first := send(h1)
+	assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12} [1-6] [a-zA-Z0-9]{8}$`, first)
+	assert.Equal(t, first, send(h2))
+	assert.NotEqual(t, first, send(h1))
+}
+
+func TestSetConfig_InvalidResponse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stubserver")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	noColon := filepath.Join(dir, "no_colon.http")
+	ioutil.WriteFile(noColon, []byte("HTTP/1.1 200 OK\nContent-Type: text/plain\nX-Broken\n\nbody"), 0644)
+	badTemplate := filepath.Join(dir, "bad_template.http")
+	ioutil.WriteFile(badTemplate, []byte("HTTP/1.1 200 OK\nContent-Type: text/plain\n\nline 4\n{{.Query.name | nope}}"), 0644)
+
+	tests := []struct {
+		data     string
+		expected string
+	}{
+		{`{{.Query.name`, `endpoint #0 GET /users: template: data:1: unclosed action`},
+		{`{{nope}}`, `endpoint #0 GET /users: template: data:1: function "nope" not defined`},
+		{"@" + noColon, `endpoint #0 GET /users: response file ` + noColon + `: line 3: header 'X-Broken' has no colon`},
+		{"@" + badTemplate, `endpoint #0 GET /users: template: ` + badTemplate + `:5: function "nope" not defined`},
+		{"@" + filepath.Join(dir, "missing.http"), `endpoint #0 GET /users: cannot open response file: stat ` + filepath.Join(dir, "missing.http") + `: no such file or directory`},
+	}
+
+	for _, test := range tests {
+		h := http.NewHandler(stubserver.Config{})
+		err := h.SetConfig(stubserver.Config{
+			Endpoints: []stubserver.ConfigRequest{
+				{URL: "/users", Method: "GET", Response: stubserver.ConfigResponse{Data: test.data}},
+			},
+		})
+		if assert.Error(t, err, test.data) {
+			assert.Equal(t, test.expected, err.Error())
+		}
+	}
+}
+
+func TestGeneric_TemplateError(t *testing.T) {
+	cfg := stubserver.Config{
+		Endpoints: []stubserver.ConfigRequest{
+			{
+				ID: "average",
+				Response: stubserver.ConfigResponse{
+					StatusCode: gohttp.StatusOK,
+					Data:       `{"average": {{div .Query.total .Query.count}}}`,
+				},
+			},
+		},
+	}
+
+	h := http.NewHandler(cfg)
+
+	w := httptest.NewRecorder()
+	h.Generic(w, httptest.NewRequest(gohttp.MethodGet, "/average?total=10&count=0", nil))
+	assert.Equal(t, gohttp.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), `"error":"template_error"`)
+	assert.Contains(t, w.Body.String(), `endpoint average: template: data:1:`)
+	assert.Contains(t, w.Body.String(), `error calling div: division by zero`)
+}
+
+func TestGeneric_TemplateData(t *testing.T) {
+	cfg := stubserver.Config{
+		Endpoints: []stubserver.ConfigRequest{
+			{
+				URL: "/json",
+				Response: stubserver.ConfigResponse{
+					StatusCode: gohttp.StatusOK,
+					Data:       `{{.JSON.name}} {{index .Path 0}} {{.Headers.Authorization}} {{.Cookies.session}} {{.Body}}`,
+				},
+			},
+			{
+				URL: "/xml",
+				Response: stubserver.ConfigResponse{
+					StatusCode: gohttp.StatusOK,
+					Data:       `{{.XML.user.id}} {{.XML.user.name}} {{index .XML.user.role 1}}`,
+				},
+			},
+			{
+				URL: "/upload",
+				Response: stubserver.ConfigResponse{
+					StatusCode: gohttp.StatusOK,
+					Data:       `{{.Form.title}} {{.Files.avatar.Filename}} {{.Files.avatar.ContentType}} {{.Files.avatar.Size}}`,
+				},
+			},
+		},
+	}
+
+	h := http.NewHandler(cfg)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(gohttp.MethodPost, "/json", strings.NewReader(`{"name":"Wilhelm"}`))
+	req.Header.Set("Content-Type", "application/vnd.api+json; charset=utf-8")
+	req.Header.Set("Authorization", "Bearer token")
+	req.AddCookie(&gohttp.Cookie{Name: "session", Value: "s1"})
+	h.Generic(w, req)
+	assert.Equal(t, `Wilhelm json Bearer token s1 {"name":"Wilhelm"}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(gohttp.MethodPost, "/xml", strings.NewReader(`<user id="1"><name>Wilhelm</name><role>admin</role><role>dev</role></user>`))
+	req.Header.Set("Content-Type", "text/xml; charset=utf-8")
+	h.Generic(w, req)
+	assert.Equal(t, `1 Wilhelm dev`, w.Body.String())
+
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+	mw.WriteField("title", "Profile")
+	part, _ := mw.CreateFormFile("avatar", "avatar.png")
+	part.Write([]byte("12345"))
+	mw.Close()
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(gohttp.MethodPost, "/upload", body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	h.Generic(w, req)
+	assert.Equal(t, `Profile avatar.png application/octet-stream 5`, w.Body.String())
+}
+
+func TestGeneric_TemplateHeadersAndStatusCode(t *testing.T) {
+	var cfg stubserver.Config
+	err := yaml.Unmarshal([]byte(`
+endpoints:
+  - url: /users/{id}
+    method: PUT
+    response:
+      headers:
+        Location: /users/{{.Params.id}}
+        X-Request-Id: '{{index .Headers "X-Request-Id" | default "none"}}'
+      statuscode: '{{if eq .Params.id "0"}}404{{else}}201{{end}}'
+      data: '{"id":{{.Params.id}}}'
+  - id: invalid
+    url: /invalid
+    response:
+      statuscode: '{{.Query.status}}'
+      data: invalid
+`), &cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, `{{if eq .Params.id "0"}}404{{else}}201{{end}}`, cfg.Endpoints[0].Response.StatusCodeTemplate)
+
+	h := http.NewHandler(cfg)
+
+	w := httptest.NewRecorder()
+	h.Generic(w, httptest.NewRequest(gohttp.MethodPut, "/users/3", nil))
+	assert.Equal(t, gohttp.StatusCreated, w.Code)
+	assert.Equal(t, "/users/3", w.Header().Get("Location"))
+	assert.Equal(t, "none", w.Header().Get("X-Request-Id"))
+	assert.Equal(t, `{"id":3}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	h.Generic(w, httptest.NewRequest(gohttp.MethodPut, "/users/0", nil))
+	assert.Equal(t, gohttp.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	h.Generic(w, httptest.NewRequest(gohttp.MethodGet, "/invalid?status=abc", nil))
+	assert.Equal(t, gohttp.StatusInternalServerError, w.Code)
+	assert.JSONEq(t, `{"error": "template_error", "message": "endpoint invalid: status code 'abc' is not valid"}`, w.Body.String())
+}
+
+func TestGeneric_TemplateHeadersWithoutData(t *testing.T) {
+	// the example of the README
+	var cfg stubserver.Config
+	err := yaml.Unmarshal([]byte(`
+endpoints:
+  - url: /users/{id}
+    method: POST
+    response:
+      headers:
+        Location: /users/{{.Params.id}}
+      statuscode: '{{if eq .Params.id "0"}}404{{else}}201{{end}}'
+`), &cfg)
+	assert.NoError(t, err)
+
+	h := http.NewHandler(cfg)
+
+	w := httptest.NewRecorder()
+	h.Generic(w, httptest.NewRequest(gohttp.MethodPost, "/users/7", nil))
+	assert.Equal(t, gohttp.StatusCreated, w.Code)
+	assert.Equal(t, "/users/7", w.Header().Get("Location"))
+	assert.Empty(t, w.Body.String())
+
+	w = httptest.NewRecorder()
+	h.Generic(w, httptest.NewRequest(gohttp.MethodPost, "/users/0", nil))
+	assert.Equal(t, gohttp.StatusNotFound, w.Code)
+	assert.Equal(t, "/users/0", w.Header().Get("Location"))
+}
+
+func TestGeneric_TemplateFileHeaders(t *testing.T) {
+	f, err := ioutil.TempFile("", "stubserver")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString("HTTP/1.1 201 Created\nLocation: /users/{{.JSON.id}}\n\n{{.JSON.id}}")
+	f.Close()
+
+	cfg := stubserver.Config{
+		Endpoints: []stubserver.ConfigRequest{
+			{Response: stubserver.ConfigResponse{Data: "@" + f.Name()}},
+		},
+	}
+
+	h := http.NewHandler(cfg)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(gohttp.MethodPost, "/users", strings.NewReader(`{"id": 7}`))
+	req.Header.Set("Content-Type", "application/json")
+	h.Generic(w, req)
+	assert.Equal(t, gohttp.StatusCreated, w.Code)
+	assert.Equal(t, "/users/7", w.Header().Get("Location"))
+	assert.Equal(t, "7", w.Body.String())
+}
+
+func TestGeneric_TemplateFileName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stubserver")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(filepath.Join(dir, "fixtures", "users"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "fixtures", "users", "1.json"), []byte(`{"id":1}`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "secret"), []byte("secret"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "stubs.yml"), []byte(`
+endpoints:
+  - url: /users/{id}
+    response:
+      statuscode: 200
+      data: '@fixtures/users/{{.Params.id}}.json'
+      filenotfound:
+        statuscode: 404
+        data: '{"error":"user {{.Params.id}} not found"}'
+  - url: /files
+    response:
+      statuscode: 200
+      data: '@fixtures/{{.Query.name}}'
+`), 0644)
+
+	cfg, err := stubserver.LoadConfig(filepath.Join(dir, "stubs.yml"))
+	assert.NoError(t, err)
+
+	h := http.NewHandler(cfg)
+
+	tests := []struct {
+		target     string
+		statusCode int
+		body       string
+	}{
+		{"/users/1", gohttp.StatusOK, `{"id":1}`},
+		{"/users/2", gohttp.StatusNotFound, `{"error":"user 2 not found"}`},
+		{"/files?name=users/1.json", gohttp.StatusOK, `{"id":1}`},
+		{"/files?name=users/2.json", gohttp.StatusNotFound, `"error":"file_not_found"`},
+		{"/files?name=../secret", gohttp.StatusOK, "secret"},
+		{"/files?name=../../secret", gohttp.StatusForbidden, `"error":"forbidden"`},
+		{"/files?name=users/../../../secret", gohttp.StatusForbidden, `"error":"forbidden"`},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		h.Generic(w, httptest.NewRequest(gohttp.MethodGet, test.target, nil))
+		assert.Equal(t, test.statusCode, w.Code, test.target)
+		assert.Contains(t, w.Body.String(), test.body, test.target)
+	}
+}
+
+func TestGeneric_ResponseFileCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stubserver")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "users.json")
+	ioutil.WriteFile(filename, []byte(`[1]`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "stubs.yml"), []byte(`
+endpoints:
+  - url: /users
+    response:
+      statuscode: 200
+      data: '@users.json'
+`), 0644)
+
+	cfg, err := stubserver.LoadConfig(filepath.Join(dir, "stubs.yml"))
+	assert.NoError(t, err)
+
+	h := http.NewHandler(cfg)
+
+	send := func() string {
+		w := httptest.NewRecorder()
+		h.Generic(w, httptest.NewRequest(gohttp.MethodGet, "/users", nil))
+		return w.Body.String()
+	}
+
+	assert.Equal(t, `[1]`, send())
+
+	// same size and modification time, it's still in the cache
+	info, _ := os.Stat(filename)
+	ioutil.WriteFile(filename, []byte(`[2]`), 0644)
+	os.Chtimes(filename, info.ModTime(), info.ModTime())
+	assert.Equal(t, `[1]`, send())
+
+	ioutil.WriteFile(filename, []byte(`[1,2]`), 0644)
+	assert.Equal(t, `[1,2]`, send())
+}
+
+func TestGeneric_RawHTTPResponseFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stubserver")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name       string
+		file       string
+		statusCode int
+		headers    map[string][]string
+		trailers   map[string][]string
+		body       string
+		// without trailers it's the length of the body served
+		contentLength string
+	}{
+		{
+			name:          "redirect",
+			file:          "HTTP/1.1 302 Found\nLocation: /login\n\n",
+			statusCode:    gohttp.StatusFound,
+			headers:       map[string][]string{"Location": {"/login"}, "Content-Type": nil},
+			contentLength: "0",
+		},
+		{
+			name:       "not_modified",
+			file:       "# cached\nHTTP/1.1 304 Not Modified\nETag: \"v1\"\n",
+			statusCode: gohttp.StatusNotModified,
+			headers:    map[string][]string{"Etag": {`"v1"`}, "Content-Type": nil},
+		},
+		{
+			name:       "no_content",
+			file:       "HTTP/1.1 204 No Content\nX-Deleted: 1\n\n",
+			statusCode: gohttp.StatusNoContent,
+			headers:    map[string][]string{"X-Deleted": {"1"}, "Content-Type": nil},
+		},
+		{
+			name:       "crlf",
+			file:       "HTTP/1.0 200 Everything Fine\r\nSet-Cookie: a=1\r\nSet-Cookie: b=2\r\nX-Folded: first\r\n  second\r\nContent-Length: 5\r\n\r\nhello",
+			statusCode: gohttp.StatusOK,
+			headers: map[string][]string{
+				"Set-Cookie": {"a=1", "b=2"},
+				"X-Folded":   {"first second"},
+			},
+			body:          "hello",
+			contentLength: "5",
+		},
+		{
+			// the file was edited, the body is still the rest of the file
+			name:          "smaller_content_length",
+			file:          "HTTP/1.1 200 OK\nContent-Length: 2\n\nhello world",
+			statusCode:    gohttp.StatusOK,
+			body:          "hello world",
+			contentLength: "11",
+		},
+		{
+			name:          "larger_content_length",
+			file:          "HTTP/1.1 200 OK\nContent-Length: 100\n\nhello world",
+			statusCode:    gohttp.StatusOK,
+			body:          "hello world",
+			contentLength: "11",
+		},
+		{
+			name:       "chunked",
+			file:       "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Checksum\r\n\r\n5\r\nhello\r\n6\r\n world\r\n0\r\nX-Checksum: abc\r\n\r\n",
+			statusCode: gohttp.StatusOK,
+			trailers:   map[string][]string{"X-Checksum": {"abc"}},
+			body:       "hello world",
+		},
+		{
+			name:          "curl",
+			file:          "HTTP/1.1 100 Continue\r\n\r\nHTTP/2 201 \r\ncontent-type: application/json\r\ntransfer-encoding: chunked\r\n\r\n{\"id\":1}",
+			statusCode:    gohttp.StatusCreated,
+			headers:       map[string][]string{"Content-Type": {"application/json"}},
+			body:          `{"id":1}`,
+			contentLength: "8",
+		},
+		{
+			name:          "curl_without_content_type",
+			file:          "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n",
+			statusCode:    gohttp.StatusOK,
+			headers:       map[string][]string{"Content-Type": nil},
+			body:          "hello",
+			contentLength: "5",
+		},
+	}
+
+	for _, test := range tests {
+		filename := filepath.Join(dir, test.name+".http")
+		ioutil.WriteFile(filename, []byte(test.file), 0644)
+
+		h := http.NewHandler(stubserver.Config{
+			Endpoints: []stubserver.ConfigRequest{
+				{Response: stubserver.ConfigResponse{Data: "@" + filename}},
+			},
+		})
+
+		w := httptest.NewRecorder()
+		h.Generic(w, httptest.NewRequest(gohttp.MethodGet, "/", nil))
+
+		resp := w.Result()
+		assert.Equal(t, test.statusCode, resp.StatusCode, test.name)
+		for k, v := range test.headers {
+			assert.Equal(t, v, resp.Header[k], test.name)
+		}
+		assert.Equal(t, test.contentLength, resp.Header.Get("Content-Length"), test.name)
+		for k, v := range test.trailers {
+			assert.Equal(t, v, resp.Trailer[k], test.name)
+		}
+		assert.Equal(t, test.body, w.Body.String(), test.name)
+	}
+}
+
+func TestGeneric_RawHTTPResponseFileWithoutContentType(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stubserver")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "users.http")
+	ioutil.WriteFile(filename, []byte("HTTP/1.1 200 OK\nX-Request-Id: 1\n\nhello"), 0644)
+
+	h := http.NewHandler(stubserver.Config{
+		Endpoints: []stubserver.ConfigRequest{
+			{Response: stubserver.ConfigResponse{Data: "@" + filename}},
+		},
+	})
+
+	server := httptest.NewServer(gohttp.HandlerFunc(h.Generic))
+	defer server.Close()
+
+	resp, err := gohttp.Get(server.URL)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, "1", resp.Header.Get("X-Request-Id"))
+		assert.Nil(t, resp.Header["Content-Type"])
+	}
+}
+
+func TestGeneric_BinaryResponseFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stubserver")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// PNG signature followed by bytes that would break a template
+	png := append([]byte("\x89PNG\r\n\x1a\n\x00\xff"), "{{ not a template"...)
+	gzip := append([]byte("\x1f\x8b\x08\x00"), "{{.Query.name}}"...)
+
+	tests := []struct {
+		name        string
+		file        []byte
+		response    stubserver.ConfigResponse
+		contentType string
+		body        []byte
+	}{
+		{"image.png", png, stubserver.ConfigResponse{}, "image/png", png},
+		{"image", png, stubserver.ConfigResponse{}, "image/png", png},
+		{"archive", gzip, stubserver.ConfigResponse{}, "application/x-gzip", gzip},
+		{"archive.http", append([]byte("HTTP/1.1 200 OK\nContent-Type: application/octet-stream\n\n"), gzip...), stubserver.ConfigResponse{}, "application/octet-stream", gzip},
+		{"users.json", []byte(`{"name": "{{.Query.name}}"}`), stubserver.ConfigResponse{}, "application/json", []byte(`{"name": "Wilhelm"}`)},
+		{"raw.json", []byte(`{"name": "{{.Query.name}}"}`), stubserver.ConfigResponse{Template: stubserver.TemplateNone}, "application/json", []byte(`{"name": "{{.Query.name}}"}`)},
+	}
+
+	for _, test := range tests {
+		filename := filepath.Join(dir, test.name)
+		ioutil.WriteFile(filename, test.file, 0644)
+
+		test.response.Data = "@" + filename
+		h := http.NewHandler(stubserver.Config{
+			Endpoints: []stubserver.ConfigRequest{
+				{Response: test.response},
+			},
+		})
+
+		w := httptest.NewRecorder()
+		h.Generic(w, httptest.NewRequest(gohttp.MethodGet, "/?name=Wilhelm", nil))
+
+		assert.Equal(t, gohttp.StatusOK, w.Code, test.name)
+		assert.Equal(t, test.contentType, w.Header().Get("Content-Type"), test.name)
+		assert.Equal(t, strconv.Itoa(len(test.body)), w.Header().Get("Content-Length"), test.name)
+		assert.Equal(t, test.body, w.Body.Bytes(), test.name)
+	}
+}
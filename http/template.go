@@ -0,0 +1,284 @@
+package http
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/guilherme-santos/stubserver"
+)
+
+// bodyTemplate is a parsed response body, it's implemented by text/template and
+// html/template.
+type bodyTemplate interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// rawBody is sent as it is, it's used when the template engine is none.
+type rawBody string
+
+func (b rawBody) Execute(w io.Writer, data interface{}) error {
+	_, err := io.WriteString(w, string(b))
+	return err
+}
+
+// parseTemplate parses text with the given engine and functions, when engine is
+// empty html/template is used for HTML content types and text/template for the others.
+func parseTemplate(name, text, engine string, header http.Header, funcs map[string]interface{}) (bodyTemplate, error) {
+	if engine == "" {
+		engine = stubserver.TemplateText
+		if isHTML(header.Get("Content-Type")) {
+			engine = stubserver.TemplateHTML
+		}
+	}
+
+	switch engine {
+	case stubserver.TemplateText:
+		return texttemplate.New(name).Funcs(texttemplate.FuncMap(funcs)).Parse(text)
+	case stubserver.TemplateHTML:
+		return htmltemplate.New(name).Funcs(htmltemplate.FuncMap(funcs)).Parse(text)
+	case stubserver.TemplateNone:
+		return rawBody(text), nil
+	}
+
+	return nil, fmt.Errorf("unknown template engine '%s'", engine)
+}
+
+// headerTemplate is a response header with its values parsed as templates.
+type headerTemplate map[string][]bodyTemplate
+
+// parseHeaderTemplate parses the values of header with text/template, the ones
+// without actions are kept as they are.
+func parseHeaderTemplate(name string, header http.Header, funcs map[string]interface{}) (headerTemplate, error) {
+	tmpl := make(headerTemplate, len(header))
+	for k, values := range header {
+		for _, v := range values {
+			if !strings.Contains(v, "{{") {
+				tmpl[k] = append(tmpl[k], rawBody(v))
+				continue
+			}
+			t, err := texttemplate.New(name + " header " + k).Funcs(texttemplate.FuncMap(funcs)).Parse(v)
+			if err != nil {
+				return nil, err
+			}
+			tmpl[k] = append(tmpl[k], t)
+		}
+	}
+	return tmpl, nil
+}
+
+func (t headerTemplate) execute(data interface{}) (http.Header, error) {
+	header := make(http.Header, len(t))
+	for k, values := range t {
+		for _, v := range values {
+			s, err := executeString(v, data)
+			if err != nil {
+				return nil, err
+			}
+			header.Add(k, s)
+		}
+	}
+	return header, nil
+}
+
+// parseFileNameTemplate parses the file name of a response with text/template.
+func parseFileNameTemplate(name string, funcs map[string]interface{}) (bodyTemplate, error) {
+	return texttemplate.New("filename").Funcs(texttemplate.FuncMap(funcs)).Parse(name)
+}
+
+// parseStatusCodeTemplate parses the template of a status code with text/template.
+func parseStatusCodeTemplate(name, text string, funcs map[string]interface{}) (bodyTemplate, error) {
+	return texttemplate.New(name + " statuscode").Funcs(texttemplate.FuncMap(funcs)).Parse(text)
+}
+
+// executeStatusCode returns the status code returned by tmpl, it must be a number
+// between 100 and 999.
+func executeStatusCode(tmpl bodyTemplate, data interface{}) (int, error) {
+	s, err := executeString(tmpl, data)
+	if err != nil {
+		return 0, err
+	}
+	s = strings.TrimSpace(s)
+	statusCode, err := strconv.Atoi(s)
+	if err != nil || statusCode < 100 || statusCode > 999 {
+		return 0, fmt.Errorf("status code '%s' is not valid", s)
+	}
+	return statusCode, nil
+}
+
+func executeString(tmpl bodyTemplate, data interface{}) (string, error) {
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func isHTML(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+// textMediaTypes are the media types, besides text/*, JSON and XML, with text bodies.
+var textMediaTypes = map[string]bool{
+	"application/javascript":            true,
+	"application/ecmascript":            true,
+	"application/x-www-form-urlencoded": true,
+	"application/yaml":                  true,
+	"application/x-yaml":                true,
+	"application/graphql":               true,
+	"application/x-ndjson":              true,
+}
+
+// isTextType reports whether the body of contentType is text, the other bodies
+// are binary and they're not templates. Without content type it's text.
+func isTextType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") || isJSON(mediaType) || isXML(mediaType) || textMediaTypes[mediaType]
+}
+
+// maxMultipartMemory is how much of a multipart body is kept in memory, the rest
+// of the files is stored in temporary files.
+const maxMultipartMemory = 32 << 20
+
+// templateFile is a file of a multipart body, available in templates as .Files.<field>.
+type templateFile struct {
+	Filename    string
+	ContentType string
+	Size        int64
+}
+
+func formFiles(form *multipart.Form) map[string]templateFile {
+	files := map[string]templateFile{}
+	for k, headers := range form.File {
+		if len(headers) == 0 {
+			continue
+		}
+		fh := headers[0]
+		f := templateFile{
+			Filename:    fh.Filename,
+			ContentType: fh.Header.Get("Content-Type"),
+		}
+		if file, err := fh.Open(); err == nil {
+			f.Size, _ = io.Copy(ioutil.Discard, file)
+			file.Close()
+		}
+		files[k] = f
+	}
+	return files
+}
+
+// firstValues returns the first value of each key, e.g. of headers or form fields.
+func firstValues(values map[string][]string) map[string]string {
+	first := make(map[string]string, len(values))
+	for k, v := range values {
+		if len(v) > 0 {
+			first[k] = v[0]
+		}
+	}
+	return first
+}
+
+// pathSegments returns the segments of path, e.g. /users/1 is [users 1].
+func pathSegments(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return []string{}
+	}
+	return strings.Split(path, "/")
+}
+
+// isJSON reports whether the media type is JSON, including the ones with suffix
+// +json (e.g. application/hal+json).
+func isJSON(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// isXML reports whether the media type is XML, including the ones with suffix +xml.
+func isXML(mediaType string) bool {
+	return mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml")
+}
+
+// decodeXML returns the document in b as maps, an element is a map with its
+// attributes and children, children with the same name are a list and the text
+// of an element with children or attributes is in _text. Elements with only text
+// are strings, e.g. <user id="1"><name>Wilhelm</name></user> is
+// {"user": {"id": "1", "name": "Wilhelm"}}.
+func decodeXML(b []byte) (map[string]interface{}, error) {
+	dec := xml.NewDecoder(bytes.NewReader(b))
+
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			root, err := decodeXMLElement(dec, start)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{start.Name.Local: root}, nil
+		}
+	}
+}
+
+func decodeXMLElement(dec *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	elem := map[string]interface{}{}
+	for _, attr := range start.Attr {
+		elem[attr.Name.Local] = attr.Value
+	}
+
+	text := new(bytes.Buffer)
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			child, err := decodeXMLElement(dec, tok)
+			if err != nil {
+				return nil, err
+			}
+			name := tok.Name.Local
+			switch existing := elem[name].(type) {
+			case nil:
+				elem[name] = child
+			case []interface{}:
+				elem[name] = append(existing, child)
+			default:
+				elem[name] = []interface{}{existing, child}
+			}
+		case xml.CharData:
+			text.Write(tok)
+		case xml.EndElement:
+			s := strings.TrimSpace(text.String())
+			if len(elem) == 0 {
+				return s, nil
+			}
+			if s != "" {
+				elem["_text"] = s
+			}
+			return elem, nil
+		}
+	}
+}
@@ -1,42 +1,374 @@
 package stubserver
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	yaml "gopkg.in/yaml.v2"
 )
 
 type Config struct {
+	// Strict makes requests not matching any endpoint receive 404 instead of the
+	// first endpoint with the same method.
+	Strict bool
+	// Fallback is the response sent when no endpoint matches, it takes precedence
+	// over Strict.
+	Fallback *ConfigResponse
+	// Upstream is the base url (e.g. http://localhost:8081) where requests not
+	// matching any endpoint and the ones matching endpoints with Proxy are sent,
+	// it takes precedence over Fallback and Strict.
+	Upstream string
+	// Seed makes the random template functions (e.g. uuid and randomInt) return
+	// the same values every time the config is loaded, 0 uses a different seed.
+	Seed int64
+	// Delay is the delay of the responses without one.
+	Delay     *ConfigDelay
 	Endpoints []ConfigRequest
+	// Dir is the directory of the config file, response file names are relative
+	// to it and the ones with templates cannot be outside of it. It's set by
+	// LoadConfig, when empty they're relative to the working directory.
+	Dir string `yaml:"-"`
+}
+
+// LoadConfig reads and validates the yaml config file.
+func LoadConfig(filename string) (Config, error) {
+	var cfg Config
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return cfg, err
+	}
+	defer f.Close()
+
+	err = yaml.NewDecoder(f).Decode(&cfg)
+	if err != nil {
+		return cfg, fmt.Errorf("cannot read yaml file %s: %s", filename, err)
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return cfg, fmt.Errorf("invalid config file %s: %s", filename, err)
+	}
+
+	cfg.Dir, err = filepath.Abs(filepath.Dir(filename))
+	if err != nil {
+		return cfg, err
+	}
+
+	return cfg, nil
+}
+
+// Validate checks the parts of the config that cannot be checked while unmarshaling.
+func (c Config) Validate() error {
+	if c.Upstream != "" {
+		if _, err := ParseUpstream(c.Upstream); err != nil {
+			return err
+		}
+	}
+	if c.Delay != nil {
+		if err := c.Delay.Validate(); err != nil {
+			return fmt.Errorf("delay: %s", err)
+		}
+	}
+	if c.Fallback != nil {
+		if err := c.Fallback.Validate(); err != nil {
+			return fmt.Errorf("fallback: %s", err)
+		}
+	}
+
+	ids := make(map[string]bool, len(c.Endpoints))
+
+	for i, endpoint := range c.Endpoints {
+		if endpoint.ID != "" {
+			if ids[endpoint.ID] {
+				return fmt.Errorf("endpoint #%d: id '%s' is duplicated", i, endpoint.ID)
+			}
+			ids[endpoint.ID] = true
+		}
+
+		if strings.HasPrefix(endpoint.URL, "~") {
+			_, err := regexp.Compile(strings.TrimSpace(endpoint.URL[1:]))
+			if err != nil {
+				return fmt.Errorf("endpoint #%d: url '%s' is not a valid regex: %s", i, endpoint.URL, err)
+			}
+		}
+
+		if err := endpoint.Response.Validate(); err != nil {
+			return fmt.Errorf("endpoint #%d: %s", i, err)
+		}
+		for j, r := range endpoint.Responses {
+			if err := r.Validate(); err != nil {
+				return fmt.Errorf("endpoint #%d: responses #%d: %s", i, j, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// MethodAny can be used as method of an endpoint to match requests of any method,
+// the same as leaving it empty.
+const MethodAny = "ANY"
+
+// ParseUpstream parses the base url of an upstream, it must have scheme and host.
+func ParseUpstream(upstream string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSpace(upstream))
+	if err != nil {
+		return nil, fmt.Errorf("upstream '%s' is not valid: %s", upstream, err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("upstream '%s' must have scheme and host, e.g. http://localhost:8081", upstream)
+	}
+	return u, nil
 }
 
 type ConfigRequest struct {
-	URL     string
-	Method  string
-	Headers http.Header
+	// ID identifies the endpoint in the admin API, it's generated when empty. For
+	// endpoints of the config file it's the same after a reload.
+	ID     string `json:"id"`
+	URL    string `json:"url"`
+	Method string `json:"method"`
+	// Methods are matched besides Method, in the config file they're given as a list
+	// in method. MethodAny in any of them matches all methods.
+	Methods []string `json:"methods,omitempty"`
+	// Priority decides between endpoints matching the same request, the highest wins.
+	// Between endpoints with the same priority the most specific wins.
+	Priority int         `json:"priority,omitempty"`
+	Headers  http.Header `json:"headers,omitempty"`
+	// HeaderMatchers are the headers using operators, in the config file they're
+	// in headers together with the others, see ValueMatcher.
+	HeaderMatchers map[string]*ValueMatcher `json:"headermatchers,omitempty"`
+	// Query matches the query string, it's an alternative to have the query string
+	// in URL when operators are needed.
+	Query map[string]*ValueMatcher `json:"query,omitempty"`
+	Body  *ConfigBody              `json:"body,omitempty"`
+	// Proxy sends the requests matching the endpoint to the upstream instead of
+	// sending Response.
+	Proxy bool `json:"proxy,omitempty"`
 	// Response can be string or ConfigResponse
 	// see UnmarshalYAML to more details
-	Response ConfigResponse
+	Response ConfigResponse `json:"response"`
+	// Responses are sent instead of Response, one per request chosen by ResponseMode,
+	// by default ResponseSequence.
+	Responses    []ConfigResponse `json:"responses,omitempty"`
+	ResponseMode string           `json:"responsemode,omitempty"`
+}
+
+// Modes of ConfigRequest.Responses.
+const (
+	// ResponseSequence sends the responses in order, after the last one it's sent
+	// again to the next requests.
+	ResponseSequence = "sequence"
+	// ResponseCycle sends the responses in order, after the last one it starts
+	// again from the first.
+	ResponseCycle = "cycle"
+	// ResponseRandom sends a random response, chosen by the weight of the responses.
+	ResponseRandom = "random"
+)
+
+// ConfigBody matches the body of the request, all criteria given must match.
+type ConfigBody struct {
+	// JSON requires the body to be equal to it, PartialJSON requires the body to
+	// contain it, objects in the body can have more fields and arrays more items.
+	JSON        interface{}      `json:"json,omitempty"`
+	PartialJSON interface{}      `json:"partialjson,omitempty"`
+	JSONPath    []ConfigJSONPath `json:"jsonpath,omitempty"`
+}
+
+// ConfigJSONPath matches the values selected by Path (e.g. $.customer.ids[0]),
+// it's enough that one value matches. Values are available in templates as
+// .JSONPath.<name>, or by path when name is empty.
+type ConfigJSONPath struct {
+	Name   string      `json:"name,omitempty"`
+	Path   string      `json:"path"`
+	Equals interface{} `json:"equals,omitempty"`
+	Regex  string      `json:"regex,omitempty"`
+	// Exists requires the path to exist, or not when false.
+	Exists *bool `json:"exists,omitempty"`
+}
+
+func (b *ConfigBody) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	hack := struct {
+		JSON        interface{}
+		PartialJSON interface{}
+		JSONPath    []ConfigJSONPath
+	}{}
+
+	if err := unmarshal(&hack); err != nil {
+		return err
+	}
+
+	var err error
+
+	b.JSON, err = yamlToJSON(hack.JSON)
+	if err != nil {
+		return fmt.Errorf("UnmarshalYAML: body: json: %s", err)
+	}
+	b.PartialJSON, err = yamlToJSON(hack.PartialJSON)
+	if err != nil {
+		return fmt.Errorf("UnmarshalYAML: body: partialjson: %s", err)
+	}
+	b.JSONPath = hack.JSONPath
+
+	return nil
+}
+
+func (p *ConfigJSONPath) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	hack := struct {
+		Name   string
+		Path   string
+		Equals interface{}
+		Regex  string
+		Exists *bool
+	}{}
+
+	if err := unmarshal(&hack); err != nil {
+		return err
+	}
+	if hack.Path == "" {
+		return fmt.Errorf("UnmarshalYAML: jsonpath: path is required")
+	}
+
+	equals, err := yamlToJSON(hack.Equals)
+	if err != nil {
+		return fmt.Errorf("UnmarshalYAML: jsonpath: %s: equals: %s", hack.Path, err)
+	}
+
+	p.Name = hack.Name
+	p.Path = hack.Path
+	p.Equals = equals
+	p.Regex = hack.Regex
+	p.Exists = hack.Exists
+
+	return nil
+}
+
+// yamlToJSON converts a value decoded from yaml to the same types encoding/json
+// would use, e.g. map[string]interface{} and float64, so they can be compared
+// with values decoded from json.
+func yamlToJSON(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	b, err := json.Marshal(stringKeys(v))
+	if err != nil {
+		return nil, err
+	}
+
+	var result interface{}
+	err = json.Unmarshal(b, &result)
+	return result, err
+}
+
+func stringKeys(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, value := range v {
+			m[fmt.Sprint(k)] = stringKeys(value)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, value := range v {
+			l[i] = stringKeys(value)
+		}
+		return l
+	}
+	return v
 }
 
+// Template engines of ConfigResponse.
+const (
+	// TemplateText uses text/template, it's the default for content types that
+	// are not HTML.
+	TemplateText = "text"
+	// TemplateHTML uses html/template, escaping values for HTML, it's the default
+	// for text/html and application/xhtml+xml.
+	TemplateHTML = "html"
+	// TemplateNone sends data as it is.
+	TemplateNone = "none"
+)
+
+// ConfigResponse is sent to requests matching an endpoint, header values and the
+// status code can also be templates.
 type ConfigResponse struct {
-	Headers    http.Header
-	StatusCode int
-	Data       string
+	Headers    http.Header `json:"headers,omitempty"`
+	StatusCode int         `json:"statuscode"`
+	// StatusCodeTemplate is a template returning the status code, it takes
+	// precedence over StatusCode. In the config file it's statuscode when it's
+	// not a number.
+	StatusCodeTemplate string `json:"statuscodetemplate,omitempty"`
+	Data               string `json:"data"`
+	// Template is the engine used with Data, it's chosen by the Content-Type
+	// header when empty.
+	Template string `json:"template,omitempty"`
+	// FileNotFound is sent when Data is a file name with templates (e.g.
+	// @users/{{.Params.id}}.json) and the file doesn't exist, by default it's 404.
+	FileNotFound *ConfigResponse `json:"filenotfound,omitempty"`
+	// Weight is the chance of the response, relative to the others, when it's in
+	// the Responses of a ConfigRequest with ResponseRandom, 0 is the same as 1.
+	Weight int `json:"weight,omitempty"`
+	// Delay is how long to wait before sending the response, by default it's the
+	// delay of the config.
+	Delay *ConfigDelay `json:"delay,omitempty"`
+	// Fault makes the connection misbehave instead of sending the response.
+	Fault *ConfigFault `json:"fault,omitempty"`
+}
+
+// Validate checks the parts of the response that are checked while unmarshaling,
+// for the responses created in Go.
+func (r ConfigResponse) Validate() error {
+	if r.Delay != nil {
+		if err := r.Delay.Validate(); err != nil {
+			return fmt.Errorf("delay: %s", err)
+		}
+	}
+	if r.Fault != nil {
+		if err := r.Fault.Validate(); err != nil {
+			return fmt.Errorf("fault: %s", err)
+		}
+	}
+	if r.FileNotFound != nil {
+		if err := r.FileNotFound.Validate(); err != nil {
+			return fmt.Errorf("filenotfound: %s", err)
+		}
+	}
+	return nil
+}
+
+// isZero reports whether r has nothing set, Headers can be empty but not nil.
+func (r ConfigResponse) isZero() bool {
+	return len(r.Headers) == 0 && r.StatusCode == 0 && r.StatusCodeTemplate == "" && r.Data == "" &&
+		r.Template == "" && r.FileNotFound == nil && r.Weight == 0 && r.Delay == nil && r.Fault == nil
 }
 
 // UnmarshalYAML need to map to a totally different struct to be able receive the format
 // that we expect.
 func (c *ConfigRequest) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	hack := struct {
-		URL      string
-		Method   string
-		Headers  yaml.MapSlice
-		Response ConfigResponse
+		ID             string
+		URL            string
+		Method         interface{}
+		Methods        []string
+		Priority       int
+		Headers        yaml.MapSlice
+		HeaderMatchers map[string]*ValueMatcher
+		Query          map[string]*ValueMatcher
+		Body           *ConfigBody
+		Proxy          bool
+		Response       *ConfigResponse
+		Responses      []ConfigResponse
+		ResponseMode   string
 	}{}
 
 	if err := unmarshal(&hack); err != nil {
@@ -44,19 +376,119 @@ func (c *ConfigRequest) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	}
 
 	hack.URL = strings.TrimSpace(hack.URL)
-	// if is not regex validate URL
-	if !strings.HasPrefix(hack.URL, "~") {
+	// if is not regex or path with parameters (e.g. /users/{id}) validate URL
+	if hack.URL != "" && !strings.HasPrefix(hack.URL, "~") && !strings.Contains(hack.URL, "{") {
 		_, err := url.ParseRequestURI(hack.URL)
 		if err != nil {
 			return fmt.Errorf("url '%s' is not valid: %s", c.URL, err)
 		}
 	}
+	c.ID = strings.TrimSpace(hack.ID)
 	c.URL = hack.URL
-	c.Method = hack.Method
+	c.Methods = hack.Methods
+	switch method := hack.Method.(type) {
+	case nil:
+	case string:
+		c.Method = strings.TrimSpace(method)
+	case []interface{}:
+		for _, m := range method {
+			s, ok := m.(string)
+			if !ok {
+				return fmt.Errorf("UnmarshalYAML: method: %v is not a string", m)
+			}
+			c.Methods = append(c.Methods, strings.TrimSpace(s))
+		}
+	default:
+		return fmt.Errorf("UnmarshalYAML: method must be a string or a list")
+	}
+	c.Priority = hack.Priority
 	c.Headers = http.Header{}
-	c.Response = hack.Response
+	c.Query = hack.Query
+	c.Body = hack.Body
+	c.Proxy = hack.Proxy
+	c.Response = ConfigResponse{}
+	// endpoints written as JSON always have response, empty when they have responses
+	if hack.Response != nil && !hack.Response.isZero() {
+		if len(hack.Responses) > 0 {
+			return fmt.Errorf("UnmarshalYAML: response and responses cannot be used together")
+		}
+		c.Response = *hack.Response
+	}
+	c.Responses = hack.Responses
+	c.ResponseMode = strings.ToLower(strings.TrimSpace(hack.ResponseMode))
+	switch c.ResponseMode {
+	case "", ResponseSequence, ResponseCycle, ResponseRandom:
+	default:
+		return fmt.Errorf("UnmarshalYAML: responsemode must be %s, %s or %s", ResponseSequence, ResponseCycle, ResponseRandom)
+	}
 
-	return mapSliceToHeader(hack.Headers, c.Headers)
+	c.HeaderMatchers = map[string]*ValueMatcher{}
+	for k, m := range hack.HeaderMatchers {
+		c.HeaderMatchers[http.CanonicalHeaderKey(k)] = m
+	}
+
+	// headers with operators are maps, the others go to Headers
+	var headers yaml.MapSlice
+	for _, kv := range hack.Headers {
+		switch kv.Value.(type) {
+		case yaml.MapSlice, map[interface{}]interface{}:
+			b, err := yaml.Marshal(kv.Value)
+			if err != nil {
+				return err
+			}
+			var m ValueMatcher
+			if err := yaml.Unmarshal(b, &m); err != nil {
+				return fmt.Errorf("UnmarshalYAML: headers: %v: %s", kv.Key, err)
+			}
+			c.HeaderMatchers[http.CanonicalHeaderKey(fmt.Sprint(kv.Key))] = &m
+		default:
+			headers = append(headers, kv)
+		}
+	}
+	if len(c.HeaderMatchers) == 0 {
+		c.HeaderMatchers = nil
+	}
+
+	return mapSliceToHeader(headers, c.Headers)
+}
+
+// ValueMatcher compares the values of a header or query string. In the config file
+// it can be a string, the value must be equal to it ignoring case, or a map with
+// the operators:
+//
+//	{regex: ^Bearer .+}   some value matches the regex
+//	{absent: true}        it's not present
+//	{not: 1}              no value is equal to 1, it also accepts operators, e.g. {not: {regex: ...}}
+//
+// It's enough that one value matches when it has many, and an empty matcher only
+// requires it to be present.
+type ValueMatcher struct {
+	Equal  string        `json:"equal,omitempty"`
+	Regex  string        `json:"regex,omitempty"`
+	Absent bool          `json:"absent,omitempty"`
+	Not    *ValueMatcher `json:"not,omitempty"`
+}
+
+func (m *ValueMatcher) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err == nil {
+		*m = ValueMatcher{Equal: value}
+		return nil
+	}
+
+	hack := struct {
+		Equal  string
+		Regex  string
+		Absent bool
+		Not    *ValueMatcher
+	}{}
+
+	if err := unmarshal(&hack); err != nil {
+		return err
+	}
+
+	*m = ValueMatcher(hack)
+	return nil
 }
 
 func (r *ConfigResponse) UnmarshalYAML(unmarshal func(interface{}) error) error {
@@ -70,22 +502,266 @@ func (r *ConfigResponse) UnmarshalYAML(unmarshal func(interface{}) error) error
 	}
 
 	hack := struct {
-		Headers    yaml.MapSlice
-		StatusCode int
-		Data       string
+		Headers            yaml.MapSlice
+		StatusCode         interface{}
+		StatusCodeTemplate string
+		Data               string
+		Template           string
+		FileNotFound       *ConfigResponse
+		Weight             int
+		Delay              *ConfigDelay
+		Fault              *ConfigFault
 	}{}
 
 	if err := unmarshal(&hack); err != nil {
 		return err
 	}
 
+	switch hack.Template {
+	case "", TemplateText, TemplateHTML, TemplateNone:
+	default:
+		return fmt.Errorf("UnmarshalYAML: template must be %s, %s or %s", TemplateText, TemplateHTML, TemplateNone)
+	}
+
 	r.Headers = http.Header{}
-	r.StatusCode = hack.StatusCode
+	r.StatusCodeTemplate = hack.StatusCodeTemplate
+	switch statusCode := hack.StatusCode.(type) {
+	case nil:
+	case int:
+		r.StatusCode = statusCode
+	case string:
+		if i, err := strconv.Atoi(strings.TrimSpace(statusCode)); err == nil {
+			r.StatusCode = i
+		} else {
+			r.StatusCodeTemplate = statusCode
+		}
+	default:
+		return fmt.Errorf("UnmarshalYAML: statuscode must be a number or a template")
+	}
 	r.Data = hack.Data
+	r.Template = hack.Template
+	r.FileNotFound = hack.FileNotFound
+	if hack.Weight < 0 {
+		return fmt.Errorf("UnmarshalYAML: weight cannot be negative")
+	}
+	r.Weight = hack.Weight
+	r.Delay = hack.Delay
+	r.Fault = hack.Fault
 
 	return mapSliceToHeader(hack.Headers, r.Headers)
 }
 
+// DefaultDelayChunks is the number of chunks the body is split in when a delay
+// has Chunk but not Chunks.
+const DefaultDelayChunks = 10
+
+// ConfigDelay is how long to wait before sending the headers of a response. It's
+// Fixed, a uniform random duration between Min and Max, or a lognormal random
+// duration with Median and Sigma, e.g. median 200ms and sigma 0.5 are mostly
+// between 100ms and 400ms but sometimes much longer. Chunk is the delay between
+// the chunks of the body, it's split in Chunks chunks.
+//
+// In the config file durations are strings, e.g. 200ms or 1.5s, and a fixed delay
+// can be only the duration, e.g. delay: 200ms.
+type ConfigDelay struct {
+	Fixed  time.Duration `json:"fixed,omitempty"`
+	Min    time.Duration `json:"min,omitempty"`
+	Max    time.Duration `json:"max,omitempty"`
+	Median time.Duration `json:"median,omitempty"`
+	Sigma  float64       `json:"sigma,omitempty"`
+	Chunk  *ConfigDelay  `json:"chunk,omitempty"`
+	Chunks int           `json:"chunks,omitempty"`
+}
+
+// Validate checks that d has only one distribution and that its values are valid.
+func (d ConfigDelay) Validate() error {
+	distributions := 0
+	if d.Fixed != 0 {
+		distributions++
+	}
+	if d.Min != 0 || d.Max != 0 {
+		distributions++
+	}
+	if d.Median != 0 || d.Sigma != 0 {
+		distributions++
+	}
+
+	switch {
+	case distributions > 1:
+		return fmt.Errorf("only one of fixed, min and max, or median and sigma can be used")
+	case d.Fixed < 0 || d.Min < 0 || d.Max < 0 || d.Median < 0:
+		return fmt.Errorf("durations cannot be negative")
+	case d.Max < d.Min:
+		return fmt.Errorf("max %s is less than min %s", d.Max, d.Min)
+	case d.Sigma < 0:
+		return fmt.Errorf("sigma cannot be negative")
+	case d.Chunks < 0:
+		return fmt.Errorf("chunks cannot be negative")
+	}
+
+	if d.Chunk != nil {
+		if err := d.Chunk.Validate(); err != nil {
+			return fmt.Errorf("chunk: %s", err)
+		}
+	}
+	return nil
+}
+
+// MarshalJSON writes the durations as strings, the same as in the config file.
+func (d ConfigDelay) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Fixed  string       `json:"fixed,omitempty"`
+		Min    string       `json:"min,omitempty"`
+		Max    string       `json:"max,omitempty"`
+		Median string       `json:"median,omitempty"`
+		Sigma  float64      `json:"sigma,omitempty"`
+		Chunk  *ConfigDelay `json:"chunk,omitempty"`
+		Chunks int          `json:"chunks,omitempty"`
+	}{
+		Fixed:  formatDuration(d.Fixed),
+		Min:    formatDuration(d.Min),
+		Max:    formatDuration(d.Max),
+		Median: formatDuration(d.Median),
+		Sigma:  d.Sigma,
+		Chunk:  d.Chunk,
+		Chunks: d.Chunks,
+	})
+}
+
+func (d *ConfigDelay) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var fixed string
+	if err := unmarshal(&fixed); err == nil {
+		duration, err := time.ParseDuration(strings.TrimSpace(fixed))
+		if err != nil {
+			return fmt.Errorf("UnmarshalYAML: delay: %s", err)
+		}
+		*d = ConfigDelay{Fixed: duration}
+		return d.validate()
+	}
+
+	hack := struct {
+		Fixed  string
+		Min    string
+		Max    string
+		Median string
+		Sigma  float64
+		Chunk  *ConfigDelay
+		Chunks int
+	}{}
+
+	if err := unmarshal(&hack); err != nil {
+		return err
+	}
+
+	durations := []struct {
+		name  string
+		value string
+		dest  *time.Duration
+	}{
+		{"fixed", hack.Fixed, &d.Fixed},
+		{"min", hack.Min, &d.Min},
+		{"max", hack.Max, &d.Max},
+		{"median", hack.Median, &d.Median},
+	}
+	for _, duration := range durations {
+		*duration.dest = 0
+		if strings.TrimSpace(duration.value) == "" {
+			continue
+		}
+		var err error
+		*duration.dest, err = time.ParseDuration(strings.TrimSpace(duration.value))
+		if err != nil {
+			return fmt.Errorf("UnmarshalYAML: delay: %s: %s", duration.name, err)
+		}
+	}
+	d.Sigma = hack.Sigma
+	d.Chunk = hack.Chunk
+	d.Chunks = hack.Chunks
+
+	return d.validate()
+}
+
+func (d ConfigDelay) validate() error {
+	if err := d.Validate(); err != nil {
+		return fmt.Errorf("UnmarshalYAML: delay: %s", err)
+	}
+	return nil
+}
+
+// formatDuration returns d as a string, empty when it's 0.
+func formatDuration(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
+
+// Faults of ConfigFault.
+const (
+	// FaultReset resets the connection (TCP RST) without sending anything.
+	FaultReset = "reset"
+	// FaultEmpty closes the connection without sending anything.
+	FaultEmpty = "empty"
+	// FaultGarbage sends Bytes random bytes, instead of a response, and closes the
+	// connection.
+	FaultGarbage = "garbage"
+	// FaultTruncate sends the headers, with the Content-Length of the whole body,
+	// and only the first Bytes bytes of the body before closing the connection.
+	FaultTruncate = "truncate"
+)
+
+// DefaultFaultGarbageBytes is how many bytes FaultGarbage sends when Bytes is 0.
+const DefaultFaultGarbageBytes = 64
+
+// ConfigFault makes a response fail at the connection level. Probability, between
+// 0 and 1, is the fraction of the requests with the fault, it's 1 when nil. In the
+// config file it can be only the type, e.g. fault: reset.
+type ConfigFault struct {
+	Type        string   `json:"type"`
+	Bytes       int      `json:"bytes,omitempty"`
+	Probability *float64 `json:"probability,omitempty"`
+}
+
+// Validate checks the type, bytes and probability of f.
+func (f ConfigFault) Validate() error {
+	switch f.Type {
+	case FaultReset, FaultEmpty, FaultGarbage, FaultTruncate:
+	default:
+		return fmt.Errorf("type must be %s, %s, %s or %s", FaultReset, FaultEmpty, FaultGarbage, FaultTruncate)
+	}
+	if f.Bytes < 0 {
+		return fmt.Errorf("bytes cannot be negative")
+	}
+	if f.Probability != nil && (*f.Probability < 0 || *f.Probability > 1) {
+		return fmt.Errorf("probability must be between 0 and 1")
+	}
+	return nil
+}
+
+func (f *ConfigFault) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var faultType string
+	if err := unmarshal(&faultType); err == nil {
+		*f = ConfigFault{Type: faultType}
+	} else {
+		hack := struct {
+			Type        string
+			Bytes       int
+			Probability *float64
+		}{}
+
+		if err := unmarshal(&hack); err != nil {
+			return err
+		}
+		*f = ConfigFault(hack)
+	}
+
+	f.Type = strings.ToLower(strings.TrimSpace(f.Type))
+	if err := f.Validate(); err != nil {
+		return fmt.Errorf("UnmarshalYAML: fault: %s", err)
+	}
+	return nil
+}
+
 func mapSliceToHeader(mapSlice yaml.MapSlice, header http.Header) error {
 	for _, kv := range mapSlice {
 		key := kv.Key.(string)
@@ -115,3 +791,60 @@ func mapSliceToHeader(mapSlice yaml.MapSlice, header http.Header) error {
 
 	return nil
 }
+
+// Times is how many times a request is expected to be received, it's used to
+// verify the requests received by the server.
+type Times struct {
+	Exactly *int `json:"exactly,omitempty"`
+	AtLeast *int `json:"atleast,omitempty"`
+	AtMost  *int `json:"atmost,omitempty"`
+}
+
+func Exactly(n int) Times {
+	return Times{Exactly: &n}
+}
+
+func AtLeast(n int) Times {
+	return Times{AtLeast: &n}
+}
+
+func AtMost(n int) Times {
+	return Times{AtMost: &n}
+}
+
+func Never() Times {
+	return Exactly(0)
+}
+
+// Check reports whether count satisfies t.
+func (t Times) Check(count int) bool {
+	if t.Exactly != nil && count != *t.Exactly {
+		return false
+	}
+	if t.AtLeast != nil && count < *t.AtLeast {
+		return false
+	}
+	if t.AtMost != nil && count > *t.AtMost {
+		return false
+	}
+	return true
+}
+
+func (t Times) String() string {
+	var parts []string
+
+	if t.Exactly != nil {
+		parts = append(parts, fmt.Sprintf("exactly %d", *t.Exactly))
+	}
+	if t.AtLeast != nil {
+		parts = append(parts, fmt.Sprintf("at least %d", *t.AtLeast))
+	}
+	if t.AtMost != nil {
+		parts = append(parts, fmt.Sprintf("at most %d", *t.AtMost))
+	}
+	if len(parts) == 0 {
+		return "any number of times"
+	}
+
+	return strings.Join(parts, " and ") + " times"
+}